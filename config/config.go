@@ -3,17 +3,42 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v3"
 )
 
+// Supported values for StorageConfig.Backend.
+const (
+	StorageBackendGridFS = "gridfs" // Store files in a MongoDB GridFS bucket
+	StorageBackendLocal  = "local"  // Store files on the local filesystem
+)
+
+// Supported values for StagingConfig.Backend.
+const (
+	StagingBackendDisk  = "disk"  // Stage chunks on the local filesystem
+	StagingBackendMongo = "mongo" // Stage chunks in a MongoDB collection
+)
+
 // Config holds all configurable fields for the application, including
-// server and MongoDB connection settings.
+// server, MongoDB connection and file storage settings.
 type Config struct {
-	Server  ServerConfig  `yaml:"server"`   // Server configuration (host, port)
-	MongoDB MongoDBConfig `yaml:"mongo_db"` // MongoDB configuration (URI)
+	Server   ServerConfig   `yaml:"server"`   // Server configuration (host, port)
+	MongoDB  MongoDBConfig  `yaml:"mongo_db"` // MongoDB configuration (URI)
+	Storage  StorageConfig  `yaml:"storage"`  // Final file storage configuration
+	Staging  StagingConfig  `yaml:"staging"`  // Chunk staging configuration
+	Trash    TrashConfig    `yaml:"trash"`    // Trash bin configuration
+	Sessions SessionsConfig `yaml:"sessions"` // Upload session expiry configuration
+	Limits   LimitsConfig   `yaml:"limits"`   // Upload size limits
+	Quotas   QuotasConfig   `yaml:"quotas"`   // Default per-owner storage quota
+	Auth     AuthConfig     `yaml:"auth"`     // Authentication of API callers
+	Signing  SigningConfig  `yaml:"signing"`  // Pre-signed URLs
+	Tenancy  TenancyConfig  `yaml:"tenancy"`  // Multi-tenancy, disabled without tenants
+	Metrics  MetricsConfig  `yaml:"metrics"`  // Prometheus metrics
 }
 
 // MongoDBConfig contains the URI used to connect to the MongoDB instance.
@@ -27,10 +52,163 @@ type ServerConfig struct {
 	Port string `yaml:"port" required:"true"` // Port on which the service listens
 }
 
+// StorageConfig selects the backend used to store finalized files.
+type StorageConfig struct {
+	Backend  string `yaml:"backend"`   // Storage backend: "gridfs" (default) or "local"
+	LocalDir string `yaml:"local_dir"` // Directory used by the local backend
+}
+
+// StagingConfig selects where chunks of in-progress uploads are buffered.
+// Use the mongo backend when running several replicas behind a load balancer.
+type StagingConfig struct {
+	Backend    string `yaml:"backend"`    // Staging backend: "disk" (default) or "mongo"
+	Dir        string `yaml:"dir"`        // Directory used by the disk backend
+	Collection string `yaml:"collection"` // Collection used by the mongo backend
+}
+
+// TrashConfig controls the background purge of files in trash.
+type TrashConfig struct {
+	RetentionDays int           `yaml:"retention_days"` // Days a file stays in trash before it is purged, 0 disables purging
+	PurgeInterval time.Duration `yaml:"purge_interval"` // How often the purge runs, e.g. "1h"
+}
+
+// SessionsConfig controls the expiry of abandoned upload sessions.
+type SessionsConfig struct {
+	TTL             time.Duration `yaml:"ttl"`              // Idle time after which an in-progress session expires, e.g. "24h"
+	ReapInterval    time.Duration `yaml:"reap_interval"`    // How often expired sessions are reclaimed, e.g. "10m"
+	FinalizeTimeout time.Duration `yaml:"finalize_timeout"` // Time after which a session still finalizing is settled, e.g. "1h"
+}
+
+// LimitsConfig bounds the size of uploaded files.
+type LimitsConfig struct {
+	MaxFileSize int64 `yaml:"max_file_size"` // Largest file size accepted at init in bytes, 0 for no limit
+}
+
+// QuotasConfig sets the storage quota of owners without an explicitly
+// set quota.
+type QuotasConfig struct {
+	DefaultMaxBytes int64 `yaml:"default_max_bytes"` // Total size of stored files per owner in bytes, 0 for no limit
+	DefaultMaxFiles int64 `yaml:"default_max_files"` // Number of stored files per owner, 0 for no limit
+}
+
+// AuthConfig configures how callers authenticate. API keys are always
+// accepted, JWT bearer tokens only when a secret is set.
+type AuthConfig struct {
+	JWTSecret        string `yaml:"jwt_secret"`         // HMAC secret verifying HS256 bearer tokens, at least 32 random bytes, empty to disable JWTs
+	APIKeyCollection string `yaml:"api_key_collection"` // Collection holding the hashed API keys
+	OpenUnownedFiles bool   `yaml:"open_unowned_files"` // Let every caller access files stored before owners were recorded
+	AdminGroup       string `yaml:"admin_group"`        // Group whose members may manage the quotas of every owner, empty for none
+}
+
+// SigningConfig configures the HMAC signing of pre-signed URLs. Rotate
+// the secret by prepending the new one: URLs are signed with the first
+// secret and accepted with any of them until the old one is removed.
+type SigningConfig struct {
+	Secrets    []string      `yaml:"secrets"`     // HMAC secrets of at least 32 random bytes, the first signs, none disables pre-signed URLs
+	BaseURL    string        `yaml:"base_url"`    // External URL of the service prefixed to signed URLs, e.g. "https://files.example.com"
+	DefaultTTL time.Duration `yaml:"default_ttl"` // Lifetime of URLs when the request sets none, e.g. "15m"
+	MaxTTL     time.Duration `yaml:"max_ttl"`     // Longest lifetime a caller may request, 0 for no limit
+}
+
+// TenancyConfig configures how requests are resolved to tenants. The
+// tenant is taken from a "tenant" claim of the credentials, else it is
+// the default tenant. A tenant named by the header or the subdomain below
+// the base domain must match it.
+type TenancyConfig struct {
+	Header        string                  `yaml:"header"`         // Header naming the tenant, e.g. "X-Tenant-ID"
+	BaseDomain    string                  `yaml:"base_domain"`    // Domain whose subdomains name tenants, e.g. "files.example.com"
+	DefaultTenant string                  `yaml:"default_tenant"` // Tenant of credentials bound to none, empty to reject them
+	Tenants       map[string]TenantConfig `yaml:"tenants"`        // Known tenants by name, empty disables multi-tenancy
+}
+
+// TenantConfig isolates the files of one tenant. Unset fields inherit the
+// global configuration.
+type TenantConfig struct {
+	Database string        `yaml:"database"` // MongoDB database, "upload_service_<tenant>" by default
+	Bucket   string        `yaml:"bucket"`   // GridFS bucket and metadata collection, "uploads" by default
+	Storage  StorageConfig `yaml:"storage"`  // Final file storage of the tenant
+	Limits   LimitsConfig  `yaml:"limits"`   // Upload size limits of the tenant
+	Quotas   QuotasConfig  `yaml:"quotas"`   // Default per-owner storage quota of the tenant
+}
+
+// Tenant returns the configuration of the named tenant with unset fields
+// inherited from the global configuration, and whether the tenant is
+// known. Inherited local storage is placed in a directory of the tenant.
+func (c *Config) Tenant(name string) (TenantConfig, bool) {
+	tenant, ok := c.Tenancy.Tenants[name]
+	if !ok {
+		return TenantConfig{}, false
+	}
+	if tenant.Database == "" {
+		tenant.Database = "upload_service_" + name
+	}
+	if tenant.Storage.Backend == "" {
+		tenant.Storage.Backend = c.Storage.Backend
+	}
+	if tenant.Storage.LocalDir == "" && c.Storage.LocalDir != "" {
+		tenant.Storage.LocalDir = filepath.Join(c.Storage.LocalDir, name)
+	}
+	if tenant.Limits.MaxFileSize == 0 {
+		tenant.Limits.MaxFileSize = c.Limits.MaxFileSize
+	}
+	if tenant.Quotas.DefaultMaxBytes == 0 {
+		tenant.Quotas.DefaultMaxBytes = c.Quotas.DefaultMaxBytes
+	}
+	if tenant.Quotas.DefaultMaxFiles == 0 {
+		tenant.Quotas.DefaultMaxFiles = c.Quotas.DefaultMaxFiles
+	}
+	return tenant, true
+}
+
+// MetricsConfig controls the Prometheus metrics exposed at /metrics.
+type MetricsConfig struct {
+	SampleInterval time.Duration `yaml:"sample_interval"` // How often the session gauges are sampled, e.g. "30s"
+}
+
+// minSecretLength is the shortest accepted HMAC secret in bytes, the size
+// of an HMAC-SHA256 key without padding.
+const minSecretLength = 32
+
+// placeholderSecrets are fragments of example secrets found in sample
+// configurations, which must never protect a running service.
+var placeholderSecrets = []string{"change-me", "changeme", "change_me", "example", "placeholder"}
+
+// Validate checks the configuration for settings that are unsafe to run
+// with, such as weak or placeholder secrets.
+func (c *Config) Validate() error {
+	if c.Auth.JWTSecret != "" {
+		if err := checkSecret("auth.jwt_secret", c.Auth.JWTSecret); err != nil {
+			return err
+		}
+	}
+	for i, secret := range c.Signing.Secrets {
+		if err := checkSecret(fmt.Sprintf("signing.secrets[%d]", i), secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSecret rejects secrets that are shorter than minSecretLength or
+// look like a placeholder.
+func checkSecret(name, secret string) error {
+	lower := strings.ToLower(secret)
+	for _, placeholder := range placeholderSecrets {
+		if strings.Contains(lower, placeholder) {
+			return fmt.Errorf("%s is a placeholder, set a random secret", name)
+		}
+	}
+	if len(secret) < minSecretLength {
+		return fmt.Errorf("%s must be at least %d bytes long", name, minSecretLength)
+	}
+	return nil
+}
+
 // LoadConfig reads and parses a YAML configuration file from the given path.
 // It ensures the path is sanitized using filepath.Clean for security.
 //
-// Returns a pointer to the Config struct or an error if reading or parsing fails.
+// Returns a pointer to the Config struct or an error if reading, parsing
+// or validating fails.
 func LoadConfig(path string) (*Config, error) {
 	safePath := filepath.Clean(path)
 	data, err := os.ReadFile(safePath)
@@ -42,6 +220,9 @@ func LoadConfig(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
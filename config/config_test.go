@@ -0,0 +1,48 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	const secret = "0123456789abcdef0123456789abcdef"
+
+	tests := []struct {
+		name    string
+		auth    AuthConfig
+		signing SigningConfig
+		wantErr string
+	}{
+		{name: "no secrets"},
+		{name: "strong secrets", auth: AuthConfig{JWTSecret: secret}, signing: SigningConfig{Secrets: []string{secret, secret + "x"}}},
+		{name: "short JWT secret", auth: AuthConfig{JWTSecret: secret[:31]}, wantErr: "auth.jwt_secret"},
+		{name: "placeholder JWT secret", auth: AuthConfig{JWTSecret: "change-me"}, wantErr: "auth.jwt_secret"},
+		{name: "long placeholder JWT secret", auth: AuthConfig{JWTSecret: "CHANGE-ME-" + secret}, wantErr: "auth.jwt_secret"},
+		{name: "empty signing secret", signing: SigningConfig{Secrets: []string{secret, ""}}, wantErr: "signing.secrets[1]"},
+		{name: "short signing secret", signing: SigningConfig{Secrets: []string{"too-short"}}, wantErr: "signing.secrets[0]"},
+		{name: "placeholder signing secret", signing: SigningConfig{Secrets: []string{"change-me-too"}}, wantErr: "signing.secrets[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Auth: tt.auth, Signing: tt.signing}
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() = %v, want an error about %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfigShipped(t *testing.T) {
+	cfg, err := LoadConfig("../resource/config.yml")
+	if err != nil {
+		t.Fatalf("LoadConfig() = %v, want the shipped config to load", err)
+	}
+	if cfg.Auth.JWTSecret != "" || len(cfg.Signing.Secrets) > 0 {
+		t.Error("shipped config enables secrets, want JWTs and signing disabled")
+	}
+}
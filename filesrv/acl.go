@@ -0,0 +1,224 @@
+// Package filesrv implements per-file access control lists: the owner of
+// a stored file may share it with users and groups, or make it public.
+package filesrv
+
+import (
+	"context"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Permissions granted by an ACL entry. The owner of a file holds all of
+// them.
+const (
+	PermissionRead   = "read"   // Download the file and see it in listings
+	PermissionWrite  = "write"  // Share the file with others
+	PermissionDelete = "delete" // Move the file to trash, restore, purge or prune it
+)
+
+// ACL controls who besides its owner may access a stored file.
+type ACL struct {
+	Public bool    `bson:"public,omitempty" json:"public"`           // Whether every authenticated caller may read the file
+	Grants []Grant `bson:"grants,omitempty" json:"grants,omitempty"` // Permissions granted to users and groups
+}
+
+// IsZero reports whether the ACL grants nothing, so it is omitted from
+// the stored metadata.
+func (a ACL) IsZero() bool {
+	return !a.Public && len(a.Grants) == 0
+}
+
+// Grant gives a user or a group permissions on a file. Exactly one of
+// Principal and Group is set.
+type Grant struct {
+	Principal   string   `bson:"principal,omitempty" json:"principal,omitempty"` // User the grant applies to
+	Group       string   `bson:"group,omitempty" json:"group,omitempty"`         // Group the grant applies to
+	Permissions []string `bson:"permissions" json:"permissions"`                 // Granted Permission* constants
+}
+
+// validate checks that the grant names exactly one grantee and only known
+// permissions.
+func (g Grant) validate() error {
+	if (g.Principal == "") == (g.Group == "") {
+		return withDetails(ErrInvalidRequest, "exactly one of user and group must be set")
+	}
+	for _, p := range g.Permissions {
+		if p != PermissionRead && p != PermissionWrite && p != PermissionDelete {
+			return withDetails(ErrInvalidRequest, "unknown permission "+p)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the grant is for the identity.
+func (g Grant) appliesTo(id Identity) bool {
+	if g.Principal != "" {
+		return g.Principal == id.Principal
+	}
+	return slices.Contains(id.Groups, g.Group)
+}
+
+// Allows reports whether the identity holds the permission on the blob.
+// Blobs stored before owners were recorded have no owner, so only their
+// ACL grants access to them.
+func (m BlobMetadata) Allows(id Identity, permission string) bool {
+	if m.Owner != "" && m.Owner == id.Principal {
+		return true
+	}
+	if permission == PermissionRead && m.ACL.Public {
+		return true
+	}
+	for _, g := range m.ACL.Grants {
+		if g.appliesTo(id) && slices.Contains(g.Permissions, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// readableFilter builds the MongoDB filter on blob metadata matching
+// the files the identity may read, mirroring Allows. Files without
+// owner are matched as well if unowned is set.
+func readableFilter(id Identity, unowned bool) bson.M {
+	owners := bson.A{id.Principal}
+	if unowned {
+		owners = append(owners, nil, "")
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"metadata.owner": bson.M{"$in": owners}},
+		bson.M{"metadata.acl.public": true},
+		bson.M{"metadata.acl.grants": bson.M{"$elemMatch": bson.M{
+			"principal":   id.Principal,
+			"permissions": PermissionRead,
+		}}},
+		bson.M{"metadata.acl.grants": bson.M{"$elemMatch": bson.M{
+			"group":       bson.M{"$in": append([]string{}, id.Groups...)},
+			"permissions": PermissionRead,
+		}}},
+	}}
+}
+
+// GrantAccess adds the permissions of grant to the ACL of
+// the file, merging them into an existing grant for the same
+// user or group. The caller needs write permission and every
+// permission granted.
+func (s *fileService) GrantAccess(ctx context.Context, fileID string, grant Grant) (BlobInfo, error) {
+	if err := grant.validate(); err != nil {
+		return BlobInfo{}, err
+	}
+	if len(grant.Permissions) == 0 {
+		return BlobInfo{}, withDetails(ErrInvalidRequest, "at least one permission must be granted")
+	}
+
+	return s.updateACL(ctx, fileID, func(acl *ACL, holds func(string) bool) error {
+		if err := requireHeld(grant.Permissions, holds); err != nil {
+			return err
+		}
+		for i, g := range acl.Grants {
+			if g.Principal == grant.Principal && g.Group == grant.Group {
+				for _, p := range grant.Permissions {
+					if !slices.Contains(g.Permissions, p) {
+						acl.Grants[i].Permissions = append(acl.Grants[i].Permissions, p)
+					}
+				}
+				return nil
+			}
+		}
+		acl.Grants = append(acl.Grants, grant)
+		return nil
+	})
+}
+
+// RevokeAccess removes the permissions of grant from the ACL
+// of the file, or every permission of the user or group if
+// none are given. The caller needs write permission and every
+// permission revoked.
+func (s *fileService) RevokeAccess(ctx context.Context, fileID string, grant Grant) (BlobInfo, error) {
+	if err := grant.validate(); err != nil {
+		return BlobInfo{}, err
+	}
+
+	return s.updateACL(ctx, fileID, func(acl *ACL, holds func(string) bool) error {
+		for i, g := range acl.Grants {
+			if g.Principal != grant.Principal || g.Group != grant.Group {
+				continue
+			}
+			revoked := grant.Permissions
+			if len(revoked) == 0 {
+				revoked = g.Permissions
+			}
+			if err := requireHeld(revoked, holds); err != nil {
+				return err
+			}
+			if len(grant.Permissions) == 0 {
+				acl.Grants[i].Permissions = nil
+				continue
+			}
+			acl.Grants[i].Permissions = slices.DeleteFunc(g.Permissions, func(p string) bool {
+				return slices.Contains(grant.Permissions, p)
+			})
+		}
+		acl.Grants = slices.DeleteFunc(acl.Grants, func(g Grant) bool {
+			return len(g.Permissions) == 0
+		})
+		return nil
+	})
+}
+
+// SetPublic changes whether every authenticated caller may
+// read the file. The caller needs write and read permission.
+func (s *fileService) SetPublic(ctx context.Context, fileID string, public bool) (BlobInfo, error) {
+	return s.updateACL(ctx, fileID, func(acl *ACL, holds func(string) bool) error {
+		if err := requireHeld([]string{PermissionRead}, holds); err != nil {
+			return err
+		}
+		acl.Public = public
+		return nil
+	})
+}
+
+// requireHeld fails with ErrForbidden unless the caller holds
+// every permission, so sharing a file cannot hand out or take
+// away more than the caller was given itself.
+func requireHeld(permissions []string, holds func(string) bool) error {
+	for _, p := range permissions {
+		if !holds(p) {
+			return withDetails(ErrForbidden, "permission "+p+" is not held by the caller")
+		}
+	}
+	return nil
+}
+
+// allows reports whether the identity holds the permission on
+// the blob, like BlobMetadata.Allows. Blobs without owner are
+// open to every caller if the service is configured so.
+func (s *fileService) allows(meta BlobMetadata, id Identity, permission string) bool {
+	return meta.Allows(id, permission) || (s.openUnowned && meta.Owner == "")
+}
+
+// updateACL applies change to the ACL of the file after
+// checking that the caller may write it. change is told which
+// permissions the caller holds on the file as stored.
+func (s *fileService) updateACL(ctx context.Context, fileID string, change func(acl *ACL, holds func(string) bool) error) (BlobInfo, error) {
+	id := IdentityFromContext(ctx)
+	return s.blobs.UpdateMetadata(ctx, fileID, func(meta *BlobMetadata) error {
+		if meta.Trashed() {
+			return ErrBlobNotFound
+		}
+		if !s.allows(*meta, id, PermissionWrite) {
+			return ErrForbidden
+		}
+		stored := *meta
+		holds := func(permission string) bool {
+			return s.allows(stored, id, permission)
+		}
+		// Work on a copy, the grants may be shared with the stored state.
+		grants := slices.Clone(meta.ACL.Grants)
+		for i := range grants {
+			grants[i].Permissions = slices.Clone(grants[i].Permissions)
+		}
+		meta.ACL.Grants = grants
+		return change(&meta.ACL, holds)
+	})
+}
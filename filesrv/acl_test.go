@@ -0,0 +1,352 @@
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBlobMetadataAllows(t *testing.T) {
+	meta := BlobMetadata{
+		Owner: "alice",
+		ACL: ACL{Grants: []Grant{
+			{Principal: "bob", Permissions: []string{PermissionRead}},
+			{Group: "eng", Permissions: []string{PermissionRead, PermissionDelete}},
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		meta       BlobMetadata
+		id         Identity
+		permission string
+		want       bool
+	}{
+		{"owner writes", meta, Identity{Principal: "alice"}, PermissionWrite, true},
+		{"user grant reads", meta, Identity{Principal: "bob"}, PermissionRead, true},
+		{"user grant does not write", meta, Identity{Principal: "bob"}, PermissionWrite, false},
+		{"group grant deletes", meta, Identity{Principal: "carol", Groups: []string{"eng"}}, PermissionDelete, true},
+		{"group grant does not write", meta, Identity{Principal: "carol", Groups: []string{"eng"}}, PermissionWrite, false},
+		{"stranger reads", meta, Identity{Principal: "mallory"}, PermissionRead, false},
+		{"principal named like a group", meta, Identity{Principal: "eng"}, PermissionRead, false},
+		{"public file is read", BlobMetadata{Owner: "alice", ACL: ACL{Public: true}}, Identity{Principal: "mallory"}, PermissionRead, true},
+		{"public file is not deleted", BlobMetadata{Owner: "alice", ACL: ACL{Public: true}}, Identity{Principal: "mallory"}, PermissionDelete, false},
+		{"file without owner", BlobMetadata{}, Identity{Principal: "mallory"}, PermissionRead, false},
+		{"file without owner and anonymous caller", BlobMetadata{}, Identity{}, PermissionRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.meta.Allows(tt.id, tt.permission); got != tt.want {
+				t.Errorf("Allows(%+v, %q) = %v, want %v", tt.id, tt.permission, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileServiceACL(t *testing.T) {
+	s, fileID := newACLTestService(t, "alice", ACL{})
+	as := func(principal string) context.Context {
+		return ContextWithIdentity(context.Background(), Identity{Principal: principal})
+	}
+
+	info, err := s.GrantAccess(as("alice"), fileID, Grant{Principal: "bob", Permissions: []string{PermissionRead, PermissionWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second grant for bob is merged into the first one.
+	info, err = s.GrantAccess(as("alice"), fileID, Grant{Principal: "bob", Permissions: []string{PermissionWrite, PermissionDelete}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Metadata.ACL.Grants) != 1 || !slices.Equal(info.Metadata.ACL.Grants[0].Permissions, []string{PermissionRead, PermissionWrite, PermissionDelete}) {
+		t.Errorf("grants = %+v, want one grant for bob with every permission", info.Metadata.ACL.Grants)
+	}
+
+	if _, err := s.SetPublic(as("mallory"), fileID, true); !errors.Is(err, ErrForbidden) {
+		t.Errorf("SetPublic() by a stranger = %v, want %v", err, ErrForbidden)
+	}
+	if _, err := s.SetPublic(as("bob"), fileID, true); err != nil {
+		t.Errorf("SetPublic() by a writer = %v, want nil", err)
+	}
+
+	info, err = s.RevokeAccess(as("alice"), fileID, Grant{Principal: "bob", Permissions: []string{PermissionWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(info.Metadata.ACL.Grants[0].Permissions, []string{PermissionRead, PermissionDelete}) {
+		t.Errorf("grants = %+v, want bob to keep read and delete", info.Metadata.ACL.Grants)
+	}
+	if _, err := s.GrantAccess(as("bob"), fileID, Grant{Principal: "mallory", Permissions: []string{PermissionRead}}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("GrantAccess() after write was revoked = %v, want %v", err, ErrForbidden)
+	}
+
+	info, err = s.RevokeAccess(as("alice"), fileID, Grant{Principal: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Metadata.ACL.Grants) != 0 || !info.Metadata.ACL.Public {
+		t.Errorf("ACL = %+v, want a public file without grants", info.Metadata.ACL)
+	}
+
+	for _, grant := range []Grant{
+		{Permissions: []string{PermissionRead}},
+		{Principal: "bob", Group: "eng", Permissions: []string{PermissionRead}},
+		{Principal: "bob", Permissions: []string{"admin"}},
+		{Principal: "bob"},
+	} {
+		if _, err := s.GrantAccess(as("alice"), fileID, grant); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("GrantAccess(%+v) = %v, want %v", grant, err, ErrInvalidRequest)
+		}
+	}
+}
+
+func TestFileServiceACLChanges(t *testing.T) {
+	owner := Identity{Principal: "alice"}
+	writer := Identity{Principal: "bob"}       // may share, but not read or delete
+	sharer := Identity{Principal: "carol"}     // may read and share
+	stranger := Identity{Principal: "mallory"} // holds nothing
+
+	tests := []struct {
+		name   string
+		caller Identity
+		change func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error)
+		want   error
+		check  func(t *testing.T, acl ACL)
+	}{
+		{
+			name:   "owner grants delete",
+			caller: owner,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.GrantAccess(ctx, fileID, Grant{Principal: "dave", Permissions: []string{PermissionDelete}})
+			},
+			check: func(t *testing.T, acl ACL) {
+				if !slices.ContainsFunc(acl.Grants, func(g Grant) bool { return g.Principal == "dave" }) {
+					t.Errorf("grants = %+v, want a grant for dave", acl.Grants)
+				}
+			},
+		},
+		{
+			name:   "stranger grants read",
+			caller: stranger,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.GrantAccess(ctx, fileID, Grant{Principal: "mallory", Permissions: []string{PermissionRead}})
+			},
+			want: ErrForbidden,
+		},
+		{
+			name:   "writer grants itself delete",
+			caller: writer,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.GrantAccess(ctx, fileID, Grant{Principal: "bob", Permissions: []string{PermissionDelete}})
+			},
+			want: ErrForbidden,
+		},
+		{
+			name:   "writer grants read it does not hold",
+			caller: writer,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.GrantAccess(ctx, fileID, Grant{Principal: "mallory", Permissions: []string{PermissionRead}})
+			},
+			want: ErrForbidden,
+		},
+		{
+			name:   "writer makes the file public",
+			caller: writer,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.SetPublic(ctx, fileID, true)
+			},
+			want: ErrForbidden,
+		},
+		{
+			name:   "sharer grants read it holds",
+			caller: sharer,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.GrantAccess(ctx, fileID, Grant{Group: "eng", Permissions: []string{PermissionRead}})
+			},
+			check: func(t *testing.T, acl ACL) {
+				if !slices.ContainsFunc(acl.Grants, func(g Grant) bool { return g.Group == "eng" }) {
+					t.Errorf("grants = %+v, want a grant for eng", acl.Grants)
+				}
+			},
+		},
+		{
+			name:   "sharer makes the file public",
+			caller: sharer,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.SetPublic(ctx, fileID, true)
+			},
+			check: func(t *testing.T, acl ACL) {
+				if !acl.Public {
+					t.Error("ACL is not public")
+				}
+			},
+		},
+		{
+			name:   "writer revokes all permissions of the sharer",
+			caller: writer,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.RevokeAccess(ctx, fileID, Grant{Principal: "carol"})
+			},
+			want: ErrForbidden,
+		},
+		{
+			name:   "stranger revokes the sharer",
+			caller: stranger,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.RevokeAccess(ctx, fileID, Grant{Principal: "carol"})
+			},
+			want: ErrForbidden,
+		},
+		{
+			name:   "owner revokes the writer",
+			caller: owner,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.RevokeAccess(ctx, fileID, Grant{Principal: "bob"})
+			},
+			check: func(t *testing.T, acl ACL) {
+				if slices.ContainsFunc(acl.Grants, func(g Grant) bool { return g.Principal == "bob" }) {
+					t.Errorf("grants = %+v, want no grant for bob", acl.Grants)
+				}
+			},
+		},
+		{
+			name:   "grant without grantee",
+			caller: owner,
+			change: func(s *fileService, ctx context.Context, fileID string) (BlobInfo, error) {
+				return s.GrantAccess(ctx, fileID, Grant{Permissions: []string{PermissionRead}})
+			},
+			want: ErrInvalidRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, fileID := newACLTestService(t, owner.Principal, ACL{Grants: []Grant{
+				{Principal: "bob", Permissions: []string{PermissionWrite}},
+				{Principal: "carol", Permissions: []string{PermissionRead, PermissionWrite}},
+			}})
+
+			info, err := tt.change(s, ContextWithIdentity(context.Background(), tt.caller), fileID)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("change = %v, want %v", err, tt.want)
+			}
+
+			stored, err := s.blobs.StatByID(context.Background(), fileID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != nil {
+				if !slices.EqualFunc(stored.Metadata.ACL.Grants, []Grant{
+					{Principal: "bob", Permissions: []string{PermissionWrite}},
+					{Principal: "carol", Permissions: []string{PermissionRead, PermissionWrite}},
+				}, func(a, b Grant) bool {
+					return a.Principal == b.Principal && a.Group == b.Group && slices.Equal(a.Permissions, b.Permissions)
+				}) || stored.Metadata.ACL.Public {
+					t.Errorf("rejected change modified the ACL to %+v", stored.Metadata.ACL)
+				}
+				return
+			}
+			tt.check(t, info.Metadata.ACL)
+			tt.check(t, stored.Metadata.ACL)
+		})
+	}
+}
+
+// newACLTestService stores a file owned by owner with the ACL in a local
+// blob store and returns a service using it along with the file ID.
+func newACLTestService(t *testing.T, owner string, acl ACL) (*fileService, string) {
+	t.Helper()
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := blobs.OpenWriter(context.Background(), "report.pdf", BlobMetadata{Owner: owner, ACL: acl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &fileService{blobs: blobs}, w.ID()
+}
+
+func TestFileServiceRevisionAccess(t *testing.T) {
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fileService{blobs: blobs}
+
+	// Revision 1 belongs to someone else, revision 2 is in trash.
+	var ids []string
+	for _, owner := range []string{"alice", "mallory", "alice", "alice"} {
+		w, err := blobs.OpenWriter(context.Background(), "report.pdf", BlobMetadata{Owner: owner})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, w.ID())
+	}
+	_, err = blobs.UpdateMetadata(context.Background(), ids[2], func(meta *BlobMetadata) error {
+		deletedAt := time.Now()
+		meta.DeletedAt = &deletedAt
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ContextWithPrincipal(context.Background(), "alice")
+
+	revisions, err := s.ListRevisions(ctx, "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numbers []int
+	for _, r := range revisions {
+		numbers = append(numbers, r.Revision)
+	}
+	if !slices.Equal(numbers, []int{0, 3}) {
+		t.Errorf("ListRevisions() numbers = %v, want [0 3]", numbers)
+	}
+
+	tests := []struct {
+		revision int
+		wantID   string
+		want     error
+	}{
+		{0, ids[0], nil},
+		{1, "", ErrForbidden},
+		{2, "", ErrBlobNotFound},
+		{3, ids[3], nil},
+		{-1, ids[3], nil},
+		{-2, "", ErrBlobNotFound},
+		{-3, "", ErrForbidden},
+		{4, "", ErrBlobNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.revision), func(t *testing.T) {
+			r, err := s.DownloadFile(ctx, "report.pdf", tt.revision)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DownloadFile() = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			defer r.Close()
+			if r.Info().ID != tt.wantID {
+				t.Errorf("DownloadFile() opened %s, want %s", r.Info().ID, tt.wantID)
+			}
+		})
+	}
+
+	if _, err := s.PruneRevisions(ctx, "report.pdf", 1); !errors.Is(err, ErrForbidden) {
+		t.Errorf("PruneRevisions() over a foreign revision = %v, want %v", err, ErrForbidden)
+	}
+}
@@ -0,0 +1,155 @@
+// Package filesrv authenticates the callers of the FileService endpoints
+// using API keys stored in MongoDB or HMAC signed JWT bearer tokens.
+package filesrv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/golang-jwt/jwt/v4"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errNoCredentials is returned by an Authenticator when the request
+// carries no credentials of its kind, so the next one is tried.
+var errNoCredentials = errors.New("no credentials")
+
+// Authenticator resolves the identity of a request from the credentials
+// the transport placed on the context.
+type Authenticator interface {
+	// Authenticate returns the authenticated identity, errNoCredentials
+	// if the request carries no credentials of this kind, or an error
+	// wrapping ErrUnauthenticated if they are invalid.
+	Authenticate(ctx context.Context) (Identity, error)
+}
+
+// Authenticate returns an endpoint middleware rejecting requests none of
+// the authenticators accept with ErrUnauthenticated. The authenticated
+// identity is stored on the context for the service. Requests through a
+// pre-signed URL or upload grant verified by the transport need no
+// credentials.
+func Authenticate(authenticators ...Authenticator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			if _, ok := SignedDownloadFromContext(ctx); ok {
+				return next(ctx, request)
+			}
+			if _, ok := UploadGrantFromContext(ctx); ok {
+				return next(ctx, request)
+			}
+			for _, authenticator := range authenticators {
+				id, err := authenticator.Authenticate(ctx)
+				if errors.Is(err, errNoCredentials) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				return next(ContextWithIdentity(ctx, id), request)
+			}
+			return nil, ErrUnauthenticated
+		}
+	}
+}
+
+// Claims are the claims of the JWT bearer tokens accepted by the service.
+// The subject is the authenticated principal.
+type Claims struct {
+	jwt.RegisteredClaims
+	Groups []string `json:"groups,omitempty"` // Groups of the principal
+	Tenant string   `json:"tenant,omitempty"` // Tenant the token is bound to
+}
+
+// jwtAuthenticator verifies HMAC signed JWT bearer tokens using go-kit's
+// JWT parser.
+type jwtAuthenticator struct {
+	parse endpoint.Endpoint // go-kit parser returning the verified claims
+}
+
+// NewJWTAuthenticator creates an Authenticator accepting HS256 signed
+// bearer tokens, placed on the context by kitjwt.HTTPToContext. Tokens
+// must expire; expiry and not-before claims are enforced.
+func NewJWTAuthenticator(secret []byte) Authenticator {
+	keyFunc := func(*jwt.Token) (any, error) { return secret, nil }
+	claims := func(ctx context.Context, _ any) (any, error) {
+		return ctx.Value(kitjwt.JWTClaimsContextKey), nil
+	}
+	return &jwtAuthenticator{
+		parse: kitjwt.NewParser(keyFunc, jwt.SigningMethodHS256, func() jwt.Claims { return &Claims{} })(claims),
+	}
+}
+
+// Authenticate verifies the bearer token and returns its subject,
+// groups and tenant.
+func (a *jwtAuthenticator) Authenticate(ctx context.Context) (Identity, error) {
+	if _, ok := ctx.Value(kitjwt.JWTContextKey).(string); !ok {
+		return Identity{}, errNoCredentials
+	}
+	resp, err := a.parse(ctx, nil)
+	if err != nil {
+		return Identity{}, withDetails(ErrUnauthenticated, err.Error())
+	}
+	claims, ok := resp.(*Claims)
+	if !ok || claims.Subject == "" {
+		return Identity{}, withDetails(ErrUnauthenticated, "token has no subject")
+	}
+	if claims.ExpiresAt == nil {
+		return Identity{}, withDetails(ErrUnauthenticated, "token has no expiry")
+	}
+	return Identity{Principal: claims.Subject, Groups: claims.Groups, Tenant: claims.Tenant}, nil
+}
+
+// apiKey is the document stored per API key. Only the SHA-256 of the key
+// is stored, so a leaked collection does not leak usable keys.
+type apiKey struct {
+	Hash      string   `bson:"_id"`       // Hex SHA-256 of the key
+	Principal string   `bson:"principal"` // Principal the key authenticates as
+	Groups    []string `bson:"groups"`    // Groups of the principal
+	Tenant    string   `bson:"tenant"`    // Tenant the key is bound to, empty for the default tenant
+	Name      string   `bson:"name"`      // Description of the key
+	Revoked   bool     `bson:"revoked"`   // Whether the key was revoked
+}
+
+// apiKeyAuthenticator looks up API keys in a MongoDB collection.
+type apiKeyAuthenticator struct {
+	keys *mongo.Collection // Collection of apiKey documents
+}
+
+// NewAPIKeyAuthenticator creates an Authenticator accepting the API keys
+// stored in coll, sent in the X-API-Key header.
+func NewAPIKeyAuthenticator(coll *mongo.Collection) Authenticator {
+	return &apiKeyAuthenticator{keys: coll}
+}
+
+// Authenticate looks up the API key and returns its principal, groups
+// and tenant.
+func (a *apiKeyAuthenticator) Authenticate(ctx context.Context) (Identity, error) {
+	key, _ := ctx.Value(apiKeyContextKey).(string)
+	if key == "" {
+		return Identity{}, errNoCredentials
+	}
+
+	var stored apiKey
+	err := a.keys.FindOne(ctx, bson.M{
+		"_id":     HashAPIKey(key),
+		"revoked": bson.M{"$ne": true},
+	}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Identity{}, withDetails(ErrUnauthenticated, "invalid API key")
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Principal: stored.Principal, Groups: stored.Groups, Tenant: stored.Tenant}, nil
+}
+
+// HashAPIKey returns the hex SHA-256 under which an API key is stored.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,139 @@
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestJWTAuthenticator(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Now()
+
+	sign := func(method jwt.SigningMethod, key any, claims Claims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "alice", ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))},
+		Groups:           []string{"eng"},
+		Tenant:           "acme",
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    Identity
+		wantErr error
+	}{
+		{
+			name:  "valid",
+			token: sign(jwt.SigningMethodHS256, secret, valid),
+			want:  Identity{Principal: "alice", Groups: []string{"eng"}, Tenant: "acme"},
+		},
+		{
+			name:    "other secret",
+			token:   sign(jwt.SigningMethodHS256, []byte("fedcba9876543210fedcba9876543210"), valid),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "other algorithm",
+			token:   sign(jwt.SigningMethodHS512, secret, valid),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "unsigned",
+			token:   sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name: "expired",
+			token: sign(jwt.SigningMethodHS256, secret, Claims{RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "alice", ExpiresAt: jwt.NewNumericDate(now.Add(-time.Minute)),
+			}}),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name: "not yet valid",
+			token: sign(jwt.SigningMethodHS256, secret, Claims{RegisteredClaims: jwt.RegisteredClaims{
+				Subject: "alice", ExpiresAt: jwt.NewNumericDate(now.Add(2 * time.Hour)), NotBefore: jwt.NewNumericDate(now.Add(time.Hour)),
+			}}),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name: "without subject",
+			token: sign(jwt.SigningMethodHS256, secret, Claims{RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			}}),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "without expiry",
+			token:   sign(jwt.SigningMethodHS256, secret, Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: "alice"}}),
+			wantErr: ErrUnauthenticated,
+		},
+		{
+			name:    "malformed",
+			token:   "not.a.token",
+			wantErr: ErrUnauthenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), kitjwt.JWTContextKey, tt.token)
+			id, err := NewJWTAuthenticator(secret).Authenticate(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() = %v, want %v", err, tt.wantErr)
+			}
+			if id.Principal != tt.want.Principal || id.Tenant != tt.want.Tenant || !slices.Equal(id.Groups, tt.want.Groups) {
+				t.Errorf("Authenticate() = %+v, want %+v", id, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "alice", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		want    string
+		wantErr error
+	}{
+		{"bearer token", context.WithValue(context.Background(), kitjwt.JWTContextKey, token), "alice", nil},
+		{"invalid bearer token", context.WithValue(context.Background(), kitjwt.JWTContextKey, token+"x"), "", ErrUnauthenticated},
+		{"no credentials", context.Background(), "", ErrUnauthenticated},
+		{"pre-signed URL", ContextWithSignedDownload(context.Background(), SignedDownload{FileID: "file-1"}), anonymousPrincipal, nil},
+		{"upload grant", ContextWithUploadGrant(context.Background(), UploadGrant{SessionID: "session-1"}), anonymousPrincipal, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal string
+			e := Authenticate(NewJWTAuthenticator(secret))(func(ctx context.Context, _ any) (any, error) {
+				principal = PrincipalFromContext(ctx)
+				return nil, nil
+			})
+			if _, err := e(tt.ctx, nil); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("endpoint = %v, want %v", err, tt.wantErr)
+			}
+			if principal != tt.want {
+				t.Errorf("principal = %q, want %q", principal, tt.want)
+			}
+		})
+	}
+}
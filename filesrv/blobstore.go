@@ -0,0 +1,109 @@
+// Package filesrv defines the BlobStore abstraction used to persist
+// finalized files, decoupling the upload workflow from the storage backend.
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrBlobNotFound is returned by a BlobStore when the requested blob
+// does not exist.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// errConcurrentMetadataUpdate is returned by UpdateMetadata when the
+// metadata kept changing underneath it.
+var errConcurrentMetadataUpdate = errors.New("blob metadata modified concurrently")
+
+// BlobMetadata holds the service defined attributes stored alongside a
+// blob, e.g. in the metadata document of a GridFS file.
+type BlobMetadata struct {
+	ContentType   string     `bson:"content_type,omitempty" json:"content_type,omitempty"`     // MIME type of the content
+	SHA256        string     `bson:"sha256,omitempty" json:"sha256,omitempty"`                 // Hex SHA-256 of the content
+	MD5           string     `bson:"md5,omitempty" json:"md5,omitempty"`                       // Hex MD5 of the content
+	DeletedAt     *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`         // Time the blob was moved to trash
+	DeletedBy     string     `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`         // Principal that moved the blob to trash
+	Owner         string     `bson:"owner,omitempty" json:"owner,omitempty"`                   // Principal that uploaded the blob
+	ACL           ACL        `bson:"acl,omitempty" json:"acl,omitzero"`                        // Access granted to others than the owner
+	KeepRevisions int        `bson:"keep_revisions,omitempty" json:"keep_revisions,omitempty"` // Number of revisions of the file retained when a new one is stored
+}
+
+// Trashed reports whether the blob has been moved to trash.
+func (m BlobMetadata) Trashed() bool {
+	return m.DeletedAt != nil
+}
+
+// BlobInfo describes a blob persisted in a BlobStore.
+type BlobInfo struct {
+	ID         string       `json:"id"`          // Backend specific unique blob ID
+	Filename   string       `json:"filename"`    // Name the blob was stored under
+	Length     int64        `json:"length"`      // Size of the blob in bytes
+	ChunkSize  int32        `json:"chunk_size"`  // Storage chunk size in bytes, 0 if not chunked
+	UploadDate time.Time    `json:"upload_date"` // Time the blob was committed
+	Metadata   BlobMetadata `json:"metadata"`    // Service defined attributes
+}
+
+// BlobWriter streams the content of a new blob into a BlobStore.
+// The blob only becomes visible once Close returns without error.
+type BlobWriter interface {
+	io.WriteCloser
+
+	// ID returns the unique ID assigned to the blob being written.
+	ID() string
+
+	// SetMetadata replaces the metadata given to OpenWriter by meta on
+	// Close. If storing it fails, the blob does not become visible.
+	SetMetadata(meta BlobMetadata)
+
+	// Abort discards everything written so far. The writer must not be
+	// used after Abort.
+	Abort() error
+}
+
+// BlobReader streams the content of a stored blob. Seeking allows
+// partial reads such as HTTP range requests.
+type BlobReader interface {
+	io.ReadSeekCloser
+
+	// Info returns the description of the blob being read.
+	Info() BlobInfo
+}
+
+// LatestRevision selects the most recent file stored under a filename.
+// Revisions follow GridFS semantics: 0 is the original, 1 the first
+// replacement and so on, while -1 is the most recent, -2 the one before.
+const LatestRevision = -1
+
+// BlobStore is the final storage for assembled files. Implementations
+// must be safe for concurrent use.
+type BlobStore interface {
+	// OpenWriter starts a new blob stored under filename. The blob is
+	// never visible without meta, which carries its owner and ACL.
+	OpenWriter(ctx context.Context, filename string, meta BlobMetadata) (BlobWriter, error)
+
+	// OpenReaderByID opens the blob with the given ID.
+	OpenReaderByID(ctx context.Context, id string) (BlobReader, error)
+
+	// Delete permanently removes the blob with the given ID.
+	Delete(ctx context.Context, id string) error
+
+	// Stat returns information about the most recent blob stored under filename.
+	Stat(ctx context.Context, filename string) (BlobInfo, error)
+
+	// StatByID returns information about the blob with the given ID.
+	StatByID(ctx context.Context, id string) (BlobInfo, error)
+
+	// UpdateMetadata applies update to the metadata of the blob with the
+	// given ID and stores the result. Concurrent updates of the same blob
+	// must not be lost. Returns the updated BlobInfo.
+	UpdateMetadata(ctx context.Context, id string, update func(*BlobMetadata) error) (BlobInfo, error)
+
+	// List returns one page of the blobs matching the query.
+	List(ctx context.Context, query ListFilesQuery) (ListFilesPage, error)
+
+	// Revisions lists every blob stored under filename, oldest first, so
+	// that the slice index is the revision number.
+	Revisions(ctx context.Context, filename string) ([]BlobInfo, error)
+}
@@ -0,0 +1,439 @@
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxMetadataUpdateAttempts bounds the retries of an optimistic metadata
+// update that keeps losing against concurrent writers.
+const maxMetadataUpdateAttempts = 5
+
+// gridFSBlobStore is a BlobStore backed by a MongoDB GridFS bucket.
+type gridFSBlobStore struct {
+	bucket *gridfs.Bucket // GridFS bucket holding the files and chunks collections
+}
+
+// NewGridFSBlobStore creates a BlobStore that stores blobs in the given
+// GridFS bucket. Blob IDs are the hex form of the GridFS file ObjectID.
+func NewGridFSBlobStore(bucket *gridfs.Bucket) BlobStore {
+	return &gridFSBlobStore{bucket: bucket}
+}
+
+// OpenWriter opens a GridFS upload stream for filename. The metadata is
+// part of the files collection entry inserted on Close.
+func (g *gridFSBlobStore) OpenWriter(_ context.Context, filename string, meta BlobMetadata) (BlobWriter, error) {
+	stream, err := g.bucket.OpenUploadStream(filename, options.GridFSUpload().SetMetadata(meta))
+	if err != nil {
+		return nil, err
+	}
+	return &gridFSBlobWriter{bucket: g.bucket, stream: stream}, nil
+}
+
+// OpenReaderByID opens a GridFS download stream for the file with the given ID.
+func (g *gridFSBlobStore) OpenReaderByID(_ context.Context, id string) (BlobReader, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrBlobNotFound
+	}
+	stream, err := g.bucket.OpenDownloadStream(oid)
+	if err != nil {
+		return nil, mapGridFSError(err)
+	}
+	info, err := gridFSBlobInfo(stream.GetFile())
+	if err != nil {
+		return nil, errors.Join(err, stream.Close())
+	}
+	return &gridFSBlobReader{bucket: g.bucket, stream: stream, info: info}, nil
+}
+
+// Delete removes the GridFS file and all of its chunks.
+func (g *gridFSBlobStore) Delete(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrBlobNotFound
+	}
+	return mapGridFSError(g.bucket.DeleteContext(ctx, oid))
+}
+
+// Stat looks up the files collection entry of the latest revision of filename.
+func (g *gridFSBlobStore) Stat(ctx context.Context, filename string) (BlobInfo, error) {
+	opts := options.GridFSFind().
+		SetSort(bson.D{{Key: "uploadDate", Value: -1}}).
+		SetLimit(1)
+	return g.findOne(ctx, bson.M{"filename": filename}, opts)
+}
+
+// StatByID looks up the files collection entry with the given ID.
+func (g *gridFSBlobStore) StatByID(ctx context.Context, id string) (BlobInfo, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	return g.findOne(ctx, bson.M{"_id": oid}, options.GridFSFind().SetLimit(1))
+}
+
+// Revisions lists the files collection entries of filename by upload date.
+func (g *gridFSBlobStore) Revisions(ctx context.Context, filename string) ([]BlobInfo, error) {
+	opts := options.GridFSFind().SetSort(bson.D{{Key: "uploadDate", Value: 1}})
+	cursor, err := g.bucket.FindContext(ctx, bson.M{"filename": filename}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var infos []BlobInfo
+	for cursor.Next(ctx) {
+		var file gridfs.File
+		if err := cursor.Decode(&file); err != nil {
+			return nil, err
+		}
+		info, err := gridFSBlobInfo(&file)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, cursor.Err()
+}
+
+// List queries the files collection, translating the filters of the
+// query into a MongoDB filter and the cursor into a keyset condition on
+// the sort field and _id.
+func (g *gridFSBlobStore) List(ctx context.Context, q ListFilesQuery) (ListFilesPage, error) {
+	after, err := q.normalize()
+	if err != nil {
+		return ListFilesPage{}, err
+	}
+
+	filter := bson.M{}
+	if q.NamePrefix != "" {
+		filter["filename"] = bson.M{"$regex": "^" + regexp.QuoteMeta(q.NamePrefix)}
+	}
+	uploadDate := bson.M{}
+	if !q.UploadedAfter.IsZero() {
+		uploadDate["$gte"] = q.UploadedAfter
+	}
+	if !q.UploadedBefore.IsZero() {
+		uploadDate["$lt"] = q.UploadedBefore
+	}
+	if len(uploadDate) > 0 {
+		filter["uploadDate"] = uploadDate
+	}
+	length := bson.M{}
+	if q.MinSize > 0 {
+		length["$gte"] = q.MinSize
+	}
+	if q.MaxSize > 0 {
+		length["$lte"] = q.MaxSize
+	}
+	if len(length) > 0 {
+		filter["length"] = length
+	}
+	if q.ContentType != "" {
+		filter["metadata.content_type"] = q.ContentType
+	}
+	deletedAt := bson.M{"$exists": q.Trashed}
+	if !q.DeletedBefore.IsZero() {
+		deletedAt["$lt"] = q.DeletedBefore
+	}
+	filter["metadata.deleted_at"] = deletedAt
+
+	field, order, op := "uploadDate", 1, "$gt"
+	switch q.SortBy {
+	case SortByFilename:
+		field = "filename"
+	case SortByLength:
+		field = "length"
+	}
+	if q.Descending {
+		order, op = -1, "$lt"
+	}
+
+	if after != nil {
+		var value any = after.UploadDate
+		switch q.SortBy {
+		case SortByFilename:
+			value = after.Filename
+		case SortByLength:
+			value = after.Length
+		}
+		lastID, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return ListFilesPage{}, ErrInvalidListQuery
+		}
+		filter["$or"] = bson.A{
+			bson.M{field: bson.M{op: value}},
+			bson.M{field: value, "_id": bson.M{op: lastID}},
+		}
+	}
+	if q.ReadableBy != nil {
+		filter["$and"] = bson.A{readableFilter(*q.ReadableBy, q.ReadableUnowned)}
+	}
+
+	opts := options.GridFSFind().
+		SetSort(bson.D{{Key: field, Value: order}, {Key: "_id", Value: order}}).
+		SetLimit(int32(q.Limit + 1)) // #nosec G115 -- limit is capped by maxListLimit
+	cursor, err := g.bucket.FindContext(ctx, filter, opts)
+	if err != nil {
+		return ListFilesPage{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []BlobInfo
+	for cursor.Next(ctx) {
+		var file gridfs.File
+		if err := cursor.Decode(&file); err != nil {
+			return ListFilesPage{}, err
+		}
+		info, err := gridFSBlobInfo(&file)
+		if err != nil {
+			return ListFilesPage{}, err
+		}
+		files = append(files, info)
+	}
+	if err := cursor.Err(); err != nil {
+		return ListFilesPage{}, err
+	}
+	return paginate(q, files), nil
+}
+
+// UpdateMetadata performs an optimistic read-modify-write of the metadata
+// document: the write only applies if the stored metadata is still the one
+// that was read, otherwise the update is retried.
+func (g *gridFSBlobStore) UpdateMetadata(ctx context.Context, id string, update func(*BlobMetadata) error) (BlobInfo, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+
+	for range maxMetadataUpdateAttempts {
+		file, err := g.findFile(ctx, bson.M{"_id": oid}, options.GridFSFind().SetLimit(1))
+		if err != nil {
+			return BlobInfo{}, err
+		}
+		info, err := gridFSBlobInfo(file)
+		if err != nil {
+			return BlobInfo{}, err
+		}
+		if err := update(&info.Metadata); err != nil {
+			return BlobInfo{}, err
+		}
+
+		filter := bson.M{"_id": oid, "metadata": nil}
+		if len(file.Metadata) > 0 {
+			filter["metadata"] = file.Metadata
+		}
+		res, err := g.bucket.GetFilesCollection().UpdateOne(ctx, filter,
+			bson.M{"$set": bson.M{"metadata": info.Metadata}},
+		)
+		if err != nil {
+			return BlobInfo{}, err
+		}
+		if res.MatchedCount == 1 {
+			return info, nil
+		}
+	}
+	return BlobInfo{}, errConcurrentMetadataUpdate
+}
+
+// findOne returns the first files collection entry matching filter.
+func (g *gridFSBlobStore) findOne(ctx context.Context, filter any, opts *options.GridFSFindOptions) (BlobInfo, error) {
+	file, err := g.findFile(ctx, filter, opts)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return gridFSBlobInfo(file)
+}
+
+// findFile decodes the first files collection entry matching filter.
+func (g *gridFSBlobStore) findFile(ctx context.Context, filter any, opts *options.GridFSFindOptions) (*gridfs.File, error) {
+	cursor, err := g.bucket.FindContext(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrBlobNotFound
+	}
+
+	var file gridfs.File
+	if err := cursor.Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// gridFSBlobWriter adapts a GridFS upload stream to the BlobWriter interface.
+type gridFSBlobWriter struct {
+	bucket *gridfs.Bucket       // bucket the file is written to
+	stream *gridfs.UploadStream // underlying GridFS upload stream
+	meta   *BlobMetadata        // metadata replacing the one given at open on Close, if any
+}
+
+// Write appends p to the GridFS upload stream.
+func (w *gridFSBlobWriter) Write(p []byte) (int, error) {
+	return w.stream.Write(p)
+}
+
+// Close flushes remaining chunks and writes the files collection entry
+// with the metadata given at open. Metadata set afterwards, such as
+// digests only known once all content has been written, is stored right
+// after the stream is closed. If that fails the file is deleted again,
+// so it never stays behind without its complete metadata.
+func (w *gridFSBlobWriter) Close() error {
+	if err := w.stream.Close(); err != nil {
+		return errors.Join(err, w.stream.Abort())
+	}
+	if w.meta == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	res, err := w.bucket.GetFilesCollection().UpdateOne(ctx,
+		bson.M{"_id": w.stream.FileID},
+		bson.M{"$set": bson.M{"metadata": w.meta}},
+	)
+	if err == nil && res.MatchedCount == 0 {
+		err = ErrBlobNotFound
+	}
+	if err != nil {
+		return errors.Join(err, mapGridFSError(w.bucket.DeleteContext(ctx, w.stream.FileID)))
+	}
+	return nil
+}
+
+// SetMetadata records the metadata replacing the one given at open on Close.
+func (w *gridFSBlobWriter) SetMetadata(meta BlobMetadata) {
+	w.meta = &meta
+}
+
+// Abort removes any chunks already written for this file.
+func (w *gridFSBlobWriter) Abort() error {
+	return w.stream.Abort()
+}
+
+// ID returns the hex ObjectID of the file being written.
+func (w *gridFSBlobWriter) ID() string {
+	return objectIDHex(w.stream.FileID)
+}
+
+// gridFSBlobReader adapts a GridFS download stream to the BlobReader
+// interface. GridFS streams only move forward, so seeks are applied lazily
+// on the next Read: forward by skipping, backward by reopening the stream.
+type gridFSBlobReader struct {
+	bucket *gridfs.Bucket         // bucket used to reopen the stream on rewind
+	stream *gridfs.DownloadStream // underlying GridFS download stream
+	pos    int64                  // offset the stream is currently positioned at
+	offset int64                  // offset requested by the caller
+	info   BlobInfo               // description of the file, decoded on open
+}
+
+// Read reads the next bytes of the GridFS file from the requested offset.
+func (r *gridFSBlobReader) Read(p []byte) (int, error) {
+	if r.offset != r.pos {
+		if err := r.reposition(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.stream.Read(p)
+	r.pos += int64(n)
+	r.offset = r.pos
+	return n, err
+}
+
+// Seek records the offset for the next Read.
+func (r *gridFSBlobReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.stream.GetFile().Length + offset
+	default:
+		return 0, errors.New("gridfs: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("gridfs: negative position")
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+// Close closes the GridFS download stream.
+func (r *gridFSBlobReader) Close() error {
+	return r.stream.Close()
+}
+
+// Info describes the GridFS file being read.
+func (r *gridFSBlobReader) Info() BlobInfo {
+	return r.info
+}
+
+// reposition moves the stream to the requested offset.
+func (r *gridFSBlobReader) reposition() error {
+	if r.offset < r.pos {
+		stream, err := r.bucket.OpenDownloadStream(r.stream.GetFile().ID)
+		if err != nil {
+			return mapGridFSError(err)
+		}
+		if err := r.stream.Close(); err != nil {
+			return errors.Join(err, stream.Close())
+		}
+		r.stream, r.pos = stream, 0
+	}
+	skipped, err := r.stream.Skip(r.offset - r.pos)
+	r.pos += skipped
+	return err
+}
+
+// gridFSBlobInfo converts a GridFS file description into a BlobInfo.
+// It fails if the metadata cannot be decoded.
+func gridFSBlobInfo(file *gridfs.File) (BlobInfo, error) {
+	info := BlobInfo{
+		ID:         objectIDHex(file.ID),
+		Filename:   file.Name,
+		Length:     file.Length,
+		ChunkSize:  file.ChunkSize,
+		UploadDate: file.UploadDate,
+	}
+	if len(file.Metadata) > 0 {
+		if err := bson.Unmarshal(file.Metadata, &info.Metadata); err != nil {
+			return BlobInfo{}, fmt.Errorf("decode metadata of GridFS file %s: %w", info.ID, err)
+		}
+	}
+	return info, nil
+}
+
+// objectIDHex renders a GridFS file ID as a string, using the hex form
+// for ObjectIDs.
+func objectIDHex(id any) string {
+	if oid, ok := id.(primitive.ObjectID); ok {
+		return oid.Hex()
+	}
+	return fmt.Sprint(id)
+}
+
+// mapGridFSError translates GridFS not-found errors into ErrBlobNotFound.
+func mapGridFSError(err error) error {
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return ErrBlobNotFound
+	}
+	return err
+}
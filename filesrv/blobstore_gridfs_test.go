@@ -0,0 +1,33 @@
+package filesrv
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+)
+
+func TestGridFSBlobInfo(t *testing.T) {
+	oid := primitive.NewObjectID()
+	meta, err := bson.Marshal(BlobMetadata{Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := gridFSBlobInfo(&gridfs.File{ID: oid, Name: "a.txt", Length: 3, Metadata: meta})
+	if err != nil {
+		t.Fatalf("gridFSBlobInfo: %v", err)
+	}
+	if info.ID != oid.Hex() || info.Filename != "a.txt" || info.Length != 3 || info.Metadata.Owner != "alice" {
+		t.Errorf("gridFSBlobInfo = %+v", info)
+	}
+
+	malformed, err := bson.Marshal(bson.M{"owner": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gridFSBlobInfo(&gridfs.File{ID: oid, Metadata: malformed}); err == nil {
+		t.Error("gridFSBlobInfo with malformed metadata succeeded")
+	}
+}
@@ -0,0 +1,381 @@
+package filesrv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	localBlobDataExt = ".blob" // extension of committed blob content files
+	localBlobInfoExt = ".json" // extension of blob description sidecar files
+	localBlobPartExt = ".part" // extension of blobs still being written
+)
+
+// localBlobStore is a BlobStore that keeps blobs on the local filesystem.
+// Each blob is stored as <id>.blob next to an <id>.json sidecar holding
+// its BlobInfo. The sidecars are read once into an in-memory index kept
+// up to date as blobs are written, updated and deleted, so the store
+// directory must not be shared by several processes.
+type localBlobStore struct {
+	dir     string              // directory holding blob content and sidecar files
+	mu      sync.Mutex          // serializes sidecar updates and deletions
+	indexMu sync.Mutex          // guards index and byName
+	index   map[string]BlobInfo // blobs by ID, nil until the sidecars are loaded
+	byName  map[string][]string // IDs of the blobs stored under each filename
+}
+
+// NewLocalBlobStore creates a BlobStore rooted at dir, creating the
+// directory if it does not exist yet.
+func NewLocalBlobStore(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	return &localBlobStore{dir: dir}, nil
+}
+
+// OpenWriter creates a partial blob file that is committed on Close.
+func (l *localBlobStore) OpenWriter(_ context.Context, filename string, meta BlobMetadata) (BlobWriter, error) {
+	id := primitive.NewObjectID().Hex()
+	partPath := l.path(id, localBlobDataExt+localBlobPartExt)
+	// #nosec G304 -- path built from a generated ObjectID
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &localBlobWriter{store: l, file: f, id: id, filename: filename, meta: meta}, nil
+}
+
+// OpenReaderByID opens the content of the blob with the given ID.
+func (l *localBlobStore) OpenReaderByID(ctx context.Context, id string) (BlobReader, error) {
+	info, err := l.StatByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return l.open(info)
+}
+
+// open opens the content file of a blob described by info.
+func (l *localBlobStore) open(info BlobInfo) (BlobReader, error) {
+	// #nosec G304 -- path sanitized with filepath.Base
+	f, err := os.Open(l.path(info.ID, localBlobDataExt))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrBlobNotFound
+		}
+		return nil, err
+	}
+	return &localBlobReader{File: f, info: info}, nil
+}
+
+// Delete removes the sidecar first so the blob disappears from lookups
+// even if removing the content fails afterwards. Content left behind by
+// an interrupted write is removed as well, like GridFS removes orphaned
+// chunks, but the blob is still reported as not found. Holding the store
+// lock keeps an UpdateMetadata in flight from recreating the sidecar.
+func (l *localBlobStore) Delete(_ context.Context, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id = filepath.Base(id)
+	err := os.Remove(l.path(id, localBlobInfoExt))
+	if errors.Is(err, os.ErrNotExist) {
+		for _, ext := range []string{localBlobDataExt + localBlobPartExt, localBlobDataExt} {
+			if err := os.Remove(l.path(id, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		return ErrBlobNotFound
+	}
+	if err != nil {
+		return err
+	}
+	l.forget(id)
+	return os.Remove(l.path(id, localBlobDataExt))
+}
+
+// Stat returns the latest of the revisions stored under filename.
+func (l *localBlobStore) Stat(ctx context.Context, filename string) (BlobInfo, error) {
+	revisions, err := l.Revisions(ctx, filename)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if len(revisions) == 0 {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	return revisions[len(revisions)-1], nil
+}
+
+// StatByID reads the sidecar file of the blob with the given ID.
+func (l *localBlobStore) StatByID(_ context.Context, id string) (BlobInfo, error) {
+	return l.readInfo(id)
+}
+
+// List filters, sorts and paginates the indexed blobs in memory.
+func (l *localBlobStore) List(_ context.Context, q ListFilesQuery) (ListFilesPage, error) {
+	after, err := q.normalize()
+	if err != nil {
+		return ListFilesPage{}, err
+	}
+
+	infos, err := l.indexedBlobs("")
+	if err != nil {
+		return ListFilesPage{}, err
+	}
+
+	var files []BlobInfo
+	for _, info := range infos {
+		if !q.matches(info) {
+			continue
+		}
+		if after != nil && q.compare(sortKey(info), *after) <= 0 {
+			continue
+		}
+		files = append(files, info)
+	}
+	slices.SortFunc(files, func(a, b BlobInfo) int {
+		return q.compare(sortKey(a), sortKey(b))
+	})
+	return paginate(q, files), nil
+}
+
+// Revisions looks up the blobs stored under filename in the index
+// and orders them by upload date.
+func (l *localBlobStore) Revisions(_ context.Context, filename string) ([]BlobInfo, error) {
+	revisions, err := l.indexedBlobs(filename)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(revisions, func(i, j int) bool {
+		return revisions[i].UploadDate.Before(revisions[j].UploadDate)
+	})
+	return revisions, nil
+}
+
+// UpdateMetadata rewrites the sidecar file while holding the store lock.
+func (l *localBlobStore) UpdateMetadata(_ context.Context, id string, update func(*BlobMetadata) error) (BlobInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, err := l.readInfo(id)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if err := update(&info.Metadata); err != nil {
+		return BlobInfo{}, err
+	}
+	if err := l.writeInfo(info); err != nil {
+		return BlobInfo{}, err
+	}
+	return info, nil
+}
+
+// indexedBlobs returns the indexed blobs stored under filename, or
+// every indexed blob if filename is empty, loading the index first
+// if needed.
+func (l *localBlobStore) indexedBlobs(filename string) ([]BlobInfo, error) {
+	l.indexMu.Lock()
+	defer l.indexMu.Unlock()
+
+	if l.index == nil {
+		infos, err := l.readAll()
+		if err != nil {
+			return nil, err
+		}
+		l.index = make(map[string]BlobInfo, len(infos))
+		l.byName = make(map[string][]string)
+		for _, info := range infos {
+			l.addToIndex(info)
+		}
+	}
+
+	if filename == "" {
+		return slices.Collect(maps.Values(l.index)), nil
+	}
+	infos := make([]BlobInfo, 0, len(l.byName[filename]))
+	for _, id := range l.byName[filename] {
+		infos = append(infos, l.index[id])
+	}
+	return infos, nil
+}
+
+// remember records a written sidecar in the index, if loaded.
+func (l *localBlobStore) remember(info BlobInfo) {
+	l.indexMu.Lock()
+	defer l.indexMu.Unlock()
+	if l.index != nil {
+		l.addToIndex(info)
+	}
+}
+
+// forget drops a deleted blob from the index, if loaded.
+func (l *localBlobStore) forget(id string) {
+	l.indexMu.Lock()
+	defer l.indexMu.Unlock()
+	info, ok := l.index[id]
+	if !ok {
+		return
+	}
+	delete(l.index, id)
+	l.byName[info.Filename] = slices.DeleteFunc(l.byName[info.Filename], func(other string) bool {
+		return other == id
+	})
+	if len(l.byName[info.Filename]) == 0 {
+		delete(l.byName, info.Filename)
+	}
+}
+
+// addToIndex adds or replaces info in the index. The caller holds
+// indexMu.
+func (l *localBlobStore) addToIndex(info BlobInfo) {
+	if _, ok := l.index[info.ID]; !ok {
+		l.byName[info.Filename] = append(l.byName[info.Filename], info.ID)
+	}
+	l.index[info.ID] = info
+}
+
+// readAll loads every sidecar file in the store directory.
+func (l *localBlobStore) readAll() ([]BlobInfo, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]BlobInfo, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), localBlobInfoExt) {
+			continue
+		}
+		info, err := l.readInfo(strings.TrimSuffix(entry.Name(), localBlobInfoExt))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// readInfo decodes the sidecar file of the blob with the given ID.
+func (l *localBlobStore) readInfo(id string) (BlobInfo, error) {
+	data, err := os.ReadFile(l.path(id, localBlobInfoExt))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return BlobInfo{}, ErrBlobNotFound
+		}
+		return BlobInfo{}, err
+	}
+	var info BlobInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return BlobInfo{}, err
+	}
+	return info, nil
+}
+
+// writeInfo atomically replaces the sidecar file of a blob.
+func (l *localBlobStore) writeInfo(info BlobInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmpPath := l.path(info.ID, localBlobInfoExt+localBlobPartExt)
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, l.path(info.ID, localBlobInfoExt)); err != nil {
+		return err
+	}
+	l.remember(info)
+	return nil
+}
+
+// path returns the location of a blob file with the given extension.
+func (l *localBlobStore) path(id, ext string) string {
+	return filepath.Join(l.dir, filepath.Base(id)+ext)
+}
+
+// localBlobReader reads a committed blob file.
+type localBlobReader struct {
+	*os.File
+	info BlobInfo // description loaded from the sidecar file
+}
+
+// Info describes the blob being read.
+func (r *localBlobReader) Info() BlobInfo {
+	return r.info
+}
+
+// localBlobWriter writes a blob into a partial file and commits it by
+// renaming the file and writing its sidecar on Close.
+type localBlobWriter struct {
+	store    *localBlobStore // store the blob belongs to
+	file     *os.File        // partial content file
+	id       string          // generated blob ID
+	filename string          // name the blob is stored under
+	length   int64           // number of bytes written so far
+	meta     BlobMetadata    // metadata written to the sidecar on Close
+}
+
+// Write appends p to the partial blob file.
+func (w *localBlobWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.length += int64(n)
+	return n, err
+}
+
+// Close syncs the content to disk and makes the blob visible by writing
+// its sidecar. Without sidecar the content is removed again.
+func (w *localBlobWriter) Close() error {
+	if err := w.file.Sync(); err != nil {
+		return errors.Join(err, w.Abort())
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	partPath := w.file.Name()
+	dataPath := w.store.path(w.id, localBlobDataExt)
+	if err := os.Rename(partPath, dataPath); err != nil {
+		return err
+	}
+
+	err := w.store.writeInfo(BlobInfo{
+		ID:         w.id,
+		Filename:   w.filename,
+		Length:     w.length,
+		UploadDate: time.Now().UTC(),
+		Metadata:   w.meta,
+	})
+	if err != nil {
+		return errors.Join(err, os.Remove(dataPath))
+	}
+	return nil
+}
+
+// SetMetadata replaces the metadata written to the sidecar on Close.
+func (w *localBlobWriter) SetMetadata(meta BlobMetadata) {
+	w.meta = meta
+}
+
+// Abort closes and removes the partial blob file.
+func (w *localBlobWriter) Abort() error {
+	_ = w.file.Close()
+	if err := os.Remove(w.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ID returns the generated ID of the blob being written.
+func (w *localBlobWriter) ID() string {
+	return w.id
+}
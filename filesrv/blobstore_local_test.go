@@ -0,0 +1,276 @@
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeLocalBlob stores content under filename and returns the blob ID.
+func writeLocalBlob(t *testing.T, blobs BlobStore, filename, content string) string {
+	t.Helper()
+	w, err := blobs.OpenWriter(context.Background(), filename, BlobMetadata{Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return w.ID()
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	blobs, err := NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := blobs.Stat(ctx, "report.pdf"); !errors.Is(err, ErrBlobNotFound) {
+		t.Fatalf("Stat() on an empty store = %v, want %v", err, ErrBlobNotFound)
+	}
+
+	first := writeLocalBlob(t, blobs, "report.pdf", "first")
+	second := writeLocalBlob(t, blobs, "report.pdf", "second revision")
+	writeLocalBlob(t, blobs, "notes.txt", "other file")
+
+	info, err := blobs.Stat(ctx, "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != second || info.Filename != "report.pdf" || info.Length != int64(len("second revision")) || info.Metadata.Owner != "alice" {
+		t.Errorf("Stat() = %+v, want the second revision", info)
+	}
+
+	revisions, err := blobs.Revisions(ctx, "report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].ID != first || revisions[1].ID != second {
+		t.Errorf("Revisions() = %+v, want the first and the second revision", revisions)
+	}
+
+	r, err := blobs.OpenReaderByID(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Info().ID != second {
+		t.Errorf("OpenReaderByID() opened %s, want %s", r.Info().ID, second)
+	}
+	if _, err := r.Seek(7, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "revision" {
+		t.Errorf("OpenReaderByID() read %q after seeking, want %q", content, "revision")
+	}
+
+	if err := blobs.Delete(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := blobs.Stat(ctx, "report.pdf"); err != nil || info.ID != first {
+		t.Errorf("Stat() after delete = %+v, %v, want the first revision", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, second+localBlobDataExt)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("content of the deleted blob is still on disk: %v", err)
+	}
+	if err := blobs.Delete(ctx, second); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Delete() twice = %v, want %v", err, ErrBlobNotFound)
+	}
+	if _, err := blobs.StatByID(ctx, second); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("StatByID() of a deleted blob = %v, want %v", err, ErrBlobNotFound)
+	}
+}
+
+func TestLocalBlobWriterAbort(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	blobs, err := NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := blobs.OpenWriter(ctx, "report.pdf", BlobMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "half of the"); err != nil {
+		t.Fatal(err)
+	}
+	partPath := filepath.Join(dir, w.ID()+localBlobDataExt+localBlobPartExt)
+	if _, err := os.Stat(partPath); err != nil {
+		t.Fatalf("partial blob not written to %s: %v", partPath, err)
+	}
+	if err := w.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("store holds %d files after Abort, want none", len(entries))
+	}
+	if _, err := blobs.Stat(ctx, "report.pdf"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Stat() of an aborted blob = %v, want %v", err, ErrBlobNotFound)
+	}
+}
+
+func TestLocalBlobStoreList(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLocalBlob(t, blobs, "reports/q1.pdf", "first quarter")
+	writeLocalBlob(t, blobs, "notes.txt", "notes")
+	writeLocalBlob(t, blobs, "reports/q2.pdf", "second quarter")
+
+	q := ListFilesQuery{NamePrefix: "reports/", SortBy: SortByFilename, Limit: 1}
+	var names []string
+	for {
+		page, err := blobs.List(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range page.Files {
+			names = append(names, info.Filename)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if !slices.Equal(names, []string{"reports/q1.pdf", "reports/q2.pdf"}) {
+		t.Errorf("List() = %v, want both reports by name", names)
+	}
+
+	if _, err := blobs.List(ctx, ListFilesQuery{Cursor: "garbage"}); !errors.Is(err, ErrInvalidListQuery) {
+		t.Errorf("List() with a malformed cursor = %v, want %v", err, ErrInvalidListQuery)
+	}
+}
+
+func TestLocalBlobStoreUpdateMetadata(t *testing.T) {
+	ctx := context.Background()
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := writeLocalBlob(t, blobs, "report.pdf", "content")
+
+	errRejected := errors.New("rejected")
+	if _, err := blobs.UpdateMetadata(ctx, id, func(meta *BlobMetadata) error {
+		meta.DeletedBy = "mallory"
+		return errRejected
+	}); !errors.Is(err, errRejected) {
+		t.Fatalf("UpdateMetadata() = %v, want %v", err, errRejected)
+	}
+
+	// Concurrent updates are serialized, none of them is lost.
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := blobs.UpdateMetadata(ctx, id, func(meta *BlobMetadata) error {
+				meta.DeletedBy += "x"
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	info, err := blobs.StatByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Metadata.DeletedBy != strings.Repeat("x", 10) {
+		t.Errorf("DeletedBy = %q after 10 concurrent updates, want 10 x", info.Metadata.DeletedBy)
+	}
+
+	if _, err := blobs.UpdateMetadata(ctx, "missing", func(*BlobMetadata) error { return nil }); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("UpdateMetadata() of a missing blob = %v, want %v", err, ErrBlobNotFound)
+	}
+}
+
+func TestLocalBlobStoreDeleteInterruptedWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	blobs, err := NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer left open, as by a process that died during finalize.
+	w, err := blobs.OpenWriter(ctx, "report.pdf", BlobMetadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = w.Abort() }()
+	if _, err := io.WriteString(w, "half of the"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := blobs.Delete(ctx, w.ID()); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("Delete() of an uncommitted blob = %v, want %v", err, ErrBlobNotFound)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("Delete() left %s behind", entry.Name())
+	}
+}
+
+func TestLocalBlobStoreDeleteDuringUpdate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	blobs, err := NewLocalBlobStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 50 {
+		id := writeLocalBlob(t, blobs, "report.pdf", "content")
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = blobs.UpdateMetadata(ctx, id, func(meta *BlobMetadata) error {
+				meta.DeletedBy = "alice"
+				return nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			if err := blobs.Delete(ctx, id); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		// Whichever ran first, the blob stays deleted.
+		if info, err := blobs.StatByID(ctx, id); !errors.Is(err, ErrBlobNotFound) {
+			t.Fatalf("StatByID() after Delete = %+v, %v, want %v", info, err, ErrBlobNotFound)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Package filesrv provides checksum helpers used to verify the integrity
+// of uploaded chunks while they are streamed into staging.
+package filesrv
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Supported chunk checksum algorithms.
+const (
+	ChecksumSHA256 = "sha256" // SHA-256, hex encoded
+	ChecksumCRC32C = "crc32c" // CRC-32 with the Castagnoli polynomial, hex encoded
+)
+
+// crc32cTable is the Castagnoli table used for CRC32C checksums.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ChunkChecksum is a client supplied digest of a chunk, written as
+// "<algorithm>:<hex digest>", e.g. "sha256:9f86d0...".
+type ChunkChecksum struct {
+	Algorithm string // ChecksumSHA256 or ChecksumCRC32C
+	Value     string // Lower-case hex encoded digest
+}
+
+// ParseChunkChecksum parses a checksum in "<algorithm>:<hex digest>" form.
+// An empty string yields the zero ChunkChecksum, meaning no verification.
+func ParseChunkChecksum(s string) (ChunkChecksum, error) {
+	if s == "" {
+		return ChunkChecksum{}, nil
+	}
+	algorithm, value, ok := strings.Cut(s, ":")
+	if !ok {
+		return ChunkChecksum{}, ErrInvalidChecksum
+	}
+	checksum := ChunkChecksum{
+		Algorithm: strings.ToLower(algorithm),
+		Value:     strings.ToLower(value),
+	}
+	if _, err := hex.DecodeString(checksum.Value); err != nil {
+		return ChunkChecksum{}, ErrInvalidChecksum
+	}
+	if _, err := newChecksumHash(checksum.Algorithm); err != nil {
+		return ChunkChecksum{}, err
+	}
+	return checksum, nil
+}
+
+// IsZero reports whether no checksum was supplied.
+func (c ChunkChecksum) IsZero() bool {
+	return c.Algorithm == ""
+}
+
+// String renders the checksum in "<algorithm>:<hex digest>" form.
+func (c ChunkChecksum) String() string {
+	if c.IsZero() {
+		return ""
+	}
+	return c.Algorithm + ":" + c.Value
+}
+
+// newChecksumHash returns a hash for the given algorithm.
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	default:
+		return nil, ErrInvalidChecksum
+	}
+}
+
+// checksumReader hashes everything read through it. When an expected
+// digest is set, it reports ErrChecksumMismatch instead of io.EOF if the
+// content does not match, so stagers discard the chunk instead of
+// committing it.
+type checksumReader struct {
+	r         io.Reader // underlying chunk stream
+	hash      hash.Hash // running digest of the bytes read
+	algorithm string    // algorithm of hash
+	want      string    // expected hex digest, empty to skip verification
+}
+
+// newChecksumReader wraps r with a reader hashing its content using the
+// algorithm of expected, or SHA-256 when no checksum was supplied.
+func newChecksumReader(r io.Reader, expected ChunkChecksum) (*checksumReader, error) {
+	algorithm := expected.Algorithm
+	if expected.IsZero() {
+		algorithm = ChecksumSHA256
+	}
+	h, err := newChecksumHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumReader{r: r, hash: h, algorithm: algorithm, want: expected.Value}, nil
+}
+
+// Read reads from the underlying stream and verifies the digest at EOF.
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	if err == io.EOF && c.want != "" && c.Sum().Value != c.want {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
+
+// Sum returns the digest of the bytes read so far.
+func (c *checksumReader) Sum() ChunkChecksum {
+	return ChunkChecksum{
+		Algorithm: c.algorithm,
+		Value:     hex.EncodeToString(c.hash.Sum(nil)),
+	}
+}
@@ -0,0 +1,76 @@
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+func TestChecksumReader(t *testing.T) {
+	const content = "123456789"
+
+	tests := []struct {
+		name     string
+		checksum string
+		wantSum  string
+		wantErr  error
+	}{
+		{"no checksum", "", "sha256:15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225", nil},
+		{"sha256 match", "sha256:15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225", "sha256:15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225", nil},
+		{"sha256 match in upper case", "SHA256:15E2B0D3C33891EBB0F1EF609EC419420C20E320CE94C65FBC8C3312448EB225", "sha256:15e2b0d3c33891ebb0f1ef609ec419420c20e320ce94c65fbc8c3312448eb225", nil},
+		{"sha256 mismatch", "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", "", ErrChecksumMismatch},
+		{"crc32c match", "crc32c:e3069283", "crc32c:e3069283", nil},
+		{"crc32c mismatch", "crc32c:cbf43926", "", ErrChecksumMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checksum, err := ParseChunkChecksum(tt.checksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := newChecksumReader(strings.NewReader(content), checksum)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(r)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadAll() = %v, want %v", err, tt.wantErr)
+			}
+			if string(data) != content {
+				t.Errorf("ReadAll() read %q, want %q", data, content)
+			}
+			if err == nil && r.Sum().String() != tt.wantSum {
+				t.Errorf("Sum() = %s, want %s", r.Sum(), tt.wantSum)
+			}
+		})
+	}
+}
+
+func TestParseChunkChecksumInvalid(t *testing.T) {
+	for _, s := range []string{"e3069283", "md5:900150983cd24fb0d6963f7d28e17f72", "sha256:not-hex"} {
+		if _, err := ParseChunkChecksum(s); !errors.Is(err, ErrInvalidChecksum) {
+			t.Errorf("ParseChunkChecksum(%q) = %v, want %v", s, err, ErrInvalidChecksum)
+		}
+	}
+}
+
+func TestEncodeChecksumError(t *testing.T) {
+	tests := map[error]int{
+		ErrInvalidChecksum:  http.StatusBadRequest,
+		ErrChecksumMismatch: http.StatusUnprocessableEntity,
+	}
+	encodeError := makeErrorEncoder(log.NewNopLogger())
+	for err, want := range tests {
+		w := httptest.NewRecorder()
+		encodeError(context.Background(), withDetails(err, "chunk 3"), w)
+		if w.Code != want {
+			t.Errorf("encoding %v wrote status %d, want %d", err, w.Code, want)
+		}
+	}
+}
@@ -12,16 +12,57 @@ type Endpoints struct {
 	UploadChunk    endpoint.Endpoint
 	FinalizeUpload endpoint.Endpoint
 	AbortUpload    endpoint.Endpoint
+	UploadStatus   endpoint.Endpoint
 	Download       endpoint.Endpoint
+	DownloadByID   endpoint.Endpoint
+	FileInfo       endpoint.Endpoint
+	ListFiles      endpoint.Endpoint
+	ListRevisions  endpoint.Endpoint
+	PruneRevisions endpoint.Endpoint
+	TrashFile      endpoint.Endpoint
+	RestoreFile    endpoint.Endpoint
+	PurgeFile      endpoint.Endpoint
+	GetQuota       endpoint.Endpoint
+	SetQuota       endpoint.Endpoint
+	GrantAccess    endpoint.Endpoint
+	RevokeAccess   endpoint.Endpoint
+	SetPublic      endpoint.Endpoint
+	SignDownload   endpoint.Endpoint
+	SignUpload     endpoint.Endpoint
 }
 
-func MakeEndpoints(svc FileService) Endpoints {
+// MakeEndpoints builds the endpoints of the service, wrapping each of them
+// in the given middlewares, outermost first.
+func MakeEndpoints(svc FileService, mws ...endpoint.Middleware) Endpoints {
+	wrap := func(e endpoint.Endpoint) endpoint.Endpoint {
+		for i := len(mws) - 1; i >= 0; i-- {
+			e = mws[i](e)
+		}
+		return e
+	}
+
 	return Endpoints{
-		InitUpload:     InitUploadEndpoint(svc),
-		UploadChunk:    UploadChunkEndpoint(svc),
-		FinalizeUpload: FinalizeEndpoint(svc),
-		AbortUpload:    AbortEndpoint(svc),
-		Download:       DownloadEndpoint(svc),
+		InitUpload:     wrap(InitUploadEndpoint(svc)),
+		UploadChunk:    wrap(UploadChunkEndpoint(svc)),
+		FinalizeUpload: wrap(FinalizeEndpoint(svc)),
+		AbortUpload:    wrap(AbortEndpoint(svc)),
+		UploadStatus:   wrap(UploadStatusEndpoint(svc)),
+		Download:       wrap(DownloadEndpoint(svc)),
+		DownloadByID:   wrap(DownloadByIDEndpoint(svc)),
+		FileInfo:       wrap(FileInfoEndpoint(svc)),
+		ListFiles:      wrap(ListFilesEndpoint(svc)),
+		ListRevisions:  wrap(ListRevisionsEndpoint(svc)),
+		PruneRevisions: wrap(PruneRevisionsEndpoint(svc)),
+		TrashFile:      wrap(TrashFileEndpoint(svc)),
+		RestoreFile:    wrap(RestoreFileEndpoint(svc)),
+		PurgeFile:      wrap(PurgeFileEndpoint(svc)),
+		GetQuota:       wrap(GetQuotaEndpoint(svc)),
+		SetQuota:       wrap(SetQuotaEndpoint(svc)),
+		GrantAccess:    wrap(GrantAccessEndpoint(svc)),
+		RevokeAccess:   wrap(RevokeAccessEndpoint(svc)),
+		SetPublic:      wrap(SetPublicEndpoint(svc)),
+		SignDownload:   wrap(SignDownloadEndpoint(svc)),
+		SignUpload:     wrap(SignUploadEndpoint(svc)),
 	}
 }
 
@@ -29,7 +70,7 @@ func InitUploadEndpoint(svc FileService) endpoint.Endpoint {
 	return func(ctx context.Context, request any) (any, error) {
 		req := request.(InitUploadRequest)
 		fmt.Printf("request init %+v\n", req)
-		id, err := svc.InitUpload(ctx, req.Filename, req.TotalChunks, req.ChunkSize)
+		id, err := svc.InitUpload(ctx, req.Filename, req.TotalChunks, req.ChunkSize, req.FileSize, req.ContentType, req.KeepRevisions)
 		return InitUploadResponse{SessionID: id, Err: err}, err
 	}
 }
@@ -37,7 +78,7 @@ func InitUploadEndpoint(svc FileService) endpoint.Endpoint {
 func UploadChunkEndpoint(svc FileService) endpoint.Endpoint {
 	return func(ctx context.Context, request any) (any, error) {
 		req := request.(UploadChunkRequest)
-		err := svc.UploadChunk(ctx, req.SessionID, req.ChunkNum, req.Data)
+		err := svc.UploadChunk(ctx, req.SessionID, req.ChunkNum, req.Checksum, req.Data)
 		return GenericResponse{Err: err}, err
 	}
 }
@@ -45,8 +86,14 @@ func UploadChunkEndpoint(svc FileService) endpoint.Endpoint {
 func FinalizeEndpoint(svc FileService) endpoint.Endpoint {
 	return func(ctx context.Context, request any) (any, error) {
 		req := request.(FinalizeRequest)
-		id, err := svc.FinalizeUpload(ctx, req.SessionID)
-		return FinalizeResponse{FileID: id, Err: err}, err
+		file, err := svc.FinalizeUpload(ctx, req.SessionID)
+		return FinalizeResponse{
+			FileID: file.ID,
+			Length: file.Length,
+			SHA256: file.Metadata.SHA256,
+			MD5:    file.Metadata.MD5,
+			Err:    err,
+		}, err
 	}
 }
 
@@ -58,13 +105,195 @@ func AbortEndpoint(svc FileService) endpoint.Endpoint {
 	}
 }
 
+func UploadStatusEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(UploadStatusRequest)
+		status, err := svc.GetUploadStatus(ctx, req.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		return status, nil
+	}
+}
+
 func DownloadEndpoint(svc FileService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(DownloadRequest)
-		data, err := svc.DownloadFile(ctx, req.Filename)
+		var file BlobReader
+		var err error
+		if req.FileID != "" {
+			file, err = svc.DownloadFileByID(ctx, req.FileID)
+		} else {
+			file, err = svc.DownloadFile(ctx, req.Filename, req.Revision)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return DownloadResponse{Info: file.Info(), Content: file, Disposition: req.Disposition}, nil
+	}
+}
+
+func DownloadByIDEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(FileIDRequest)
+		file, err := svc.DownloadFileByID(ctx, req.FileID)
+		if err != nil {
+			return nil, err
+		}
+		return DownloadResponse{Info: file.Info(), Content: file}, nil
+	}
+}
+
+func FileInfoEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(FileIDRequest)
+		info, err := svc.GetFileInfo(ctx, req.FileID)
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+}
+
+func ListFilesEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(ListFilesQuery)
+		page, err := svc.ListFiles(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return page, nil
+	}
+}
+
+func ListRevisionsEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(ListRevisionsRequest)
+		revisions, err := svc.ListRevisions(ctx, req.Filename)
+		return ListRevisionsResponse{Filename: req.Filename, Revisions: revisions, Err: err}, err
+	}
+}
+
+func PruneRevisionsEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(PruneRevisionsRequest)
+		deleted, err := svc.PruneRevisions(ctx, req.Filename, req.Keep)
+		return PruneRevisionsResponse{Deleted: deleted, Err: err}, err
+	}
+}
+
+func TrashFileEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(FileIDRequest)
+		info, err := svc.TrashFile(ctx, req.FileID)
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+}
+
+func RestoreFileEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(FileIDRequest)
+		info, err := svc.RestoreFile(ctx, req.FileID)
 		if err != nil {
 			return nil, err
 		}
-		return DownloadResponse{Filename: req.Filename, Data: data}, nil
+		return info, nil
+	}
+}
+
+func PurgeFileEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(FileIDRequest)
+		err := svc.PurgeFile(ctx, req.FileID)
+		return GenericResponse{Err: err}, err
+	}
+}
+
+func GetQuotaEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(QuotaRequest)
+		usage, err := svc.GetQuota(ctx, quotaOwner(ctx, req))
+		if err != nil {
+			return nil, err
+		}
+		return usage, nil
+	}
+}
+
+func SetQuotaEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(QuotaRequest)
+		usage, err := svc.SetQuota(ctx, quotaOwner(ctx, req), req.Quota)
+		if err != nil {
+			return nil, err
+		}
+		return usage, nil
+	}
+}
+
+func GrantAccessEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(AccessRequest)
+		info, err := svc.GrantAccess(ctx, req.FileID, req.Grant)
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+}
+
+func RevokeAccessEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(AccessRequest)
+		info, err := svc.RevokeAccess(ctx, req.FileID, req.Grant)
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+}
+
+func SetPublicEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(PublicRequest)
+		info, err := svc.SetPublic(ctx, req.FileID, req.Public)
+		if err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+}
+
+func SignDownloadEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(SignDownloadRequest)
+		signed, err := svc.SignDownloadURL(ctx, req.FileID, req.DownloadURLOptions)
+		if err != nil {
+			return nil, err
+		}
+		return signed, nil
+	}
+}
+
+func SignUploadEndpoint(svc FileService) endpoint.Endpoint {
+	return func(ctx context.Context, request any) (any, error) {
+		req := request.(SignUploadRequest)
+		grant, err := svc.SignUploadGrant(ctx, req.SessionID, req.UploadGrantOptions)
+		if err != nil {
+			return nil, err
+		}
+		return grant, nil
+	}
+}
+
+// quotaOwner returns the owner of a quota request, defaulting to the
+// authenticated caller.
+func quotaOwner(ctx context.Context, req QuotaRequest) string {
+	if req.Owner != "" {
+		return req.Owner
 	}
+	return PrincipalFromContext(ctx)
 }
@@ -0,0 +1,118 @@
+// Package filesrv defines the errors returned by the FileService that the
+// transport maps to specific HTTP status codes.
+package filesrv
+
+import "errors"
+
+var (
+	// ErrInvalidRequest is returned when a request is malformed or misses a
+	// required parameter.
+	ErrInvalidRequest = errors.New("invalid request")
+
+	// ErrUnauthenticated is returned when a request carries no valid API key
+	// or bearer token.
+	ErrUnauthenticated = errors.New("authentication required")
+
+	// ErrForbidden is returned when the caller lacks the permission on a
+	// file required by the operation, or acts on an upload session it
+	// neither owns nor holds an upload grant for.
+	ErrForbidden = errors.New("access denied")
+
+	// ErrInvalidSignature is returned when a pre-signed URL has a bad
+	// signature, expired or is used from another client IP.
+	ErrInvalidSignature = errors.New("invalid or expired signed URL")
+
+	// ErrSigningDisabled is returned when a pre-signed URL is requested but
+	// no signing secret is configured.
+	ErrSigningDisabled = errors.New("pre-signed URLs are not enabled")
+
+	// ErrTenantRequired is returned when a request names no tenant and no
+	// default tenant is configured.
+	ErrTenantRequired = errors.New("tenant required")
+
+	// ErrUnknownTenant is returned when a request names a tenant that is
+	// not configured.
+	ErrUnknownTenant = errors.New("unknown tenant")
+
+	// ErrIncompleteUpload is returned when an upload session is finalized
+	// before all of its chunks were uploaded.
+	ErrIncompleteUpload = errors.New("not all chunks uploaded")
+
+	// ErrInvalidChecksum is returned when a chunk checksum is malformed or
+	// uses an unsupported algorithm.
+	ErrInvalidChecksum = errors.New("invalid chunk checksum, expected <sha256|crc32c>:<hex digest>")
+
+	// ErrChecksumMismatch is returned when the uploaded chunk does not match
+	// the checksum supplied by the client.
+	ErrChecksumMismatch = errors.New("chunk checksum mismatch")
+
+	// ErrInvalidRevision is returned when a file revision is not an integer.
+	ErrInvalidRevision = errors.New("invalid revision, expected an integer")
+
+	// ErrInvalidRetention is returned when asked to keep fewer than one
+	// revision of a file.
+	ErrInvalidRetention = errors.New("invalid retention, at least one revision must be kept")
+
+	// ErrSessionNotFound is returned when no upload session exists with the
+	// given ID.
+	ErrSessionNotFound = errors.New("upload session not found")
+
+	// ErrInvalidFileSize is returned when the declared file size, chunk size
+	// and total number of chunks of an upload do not add up.
+	ErrInvalidFileSize = errors.New("file size does not match total chunks and chunk size")
+
+	// ErrFileTooLarge is returned when the declared file size exceeds the
+	// maximum file size.
+	ErrFileTooLarge = errors.New("file exceeds the maximum file size")
+
+	// ErrFileSizeExceeded is returned when the chunks of an upload grow
+	// beyond the file size declared for the session.
+	ErrFileSizeExceeded = errors.New("upload exceeds the declared file size")
+
+	// ErrQuotaExceeded is returned when storing a file would exceed the
+	// byte or file count quota of its owner.
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+	// ErrSessionNotInProgress is returned when a chunk is uploaded to a
+	// session that was already completed, aborted or expired.
+	ErrSessionNotInProgress = errors.New("upload session is not in progress")
+
+	// ErrChunkOutOfRange is returned when a chunk number is negative or not
+	// below the total number of chunks declared for the session.
+	ErrChunkOutOfRange = errors.New("chunk number out of range")
+
+	// ErrChunkSizeMismatch is returned when a chunk other than the final one
+	// is not exactly the chunk size declared for the session.
+	ErrChunkSizeMismatch = errors.New("chunk size does not match the session chunk size")
+
+	// ErrChunkTooLarge is returned when the final chunk is larger than the
+	// chunk size declared for the session.
+	ErrChunkTooLarge = errors.New("final chunk exceeds the session chunk size")
+
+	// ErrInvalidListQuery is returned when a file listing has malformed
+	// filters, an unknown sort field or a cursor issued for another query.
+	ErrInvalidListQuery = errors.New("invalid list query")
+)
+
+// Error is a service error carrying details for the client, such as the
+// missing chunks of an incomplete upload. It matches the wrapped sentinel
+// with errors.Is.
+type Error struct {
+	Err     error // Sentinel error describing the failure
+	Details any   // Additional information encoded into the error response
+}
+
+// withDetails attaches details to err.
+func withDetails(err error, details any) error {
+	return &Error{Err: err, Details: details}
+}
+
+// Error returns the message of the wrapped error.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,185 @@
+// Package filesrv implements the expiry of abandoned upload sessions:
+// in-progress sessions idle for longer than the session TTL are marked
+// expired and their staged chunks are reclaimed. Sessions left
+// finalizing by an interrupted finalize are settled the same way.
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExpireSessions marks every in-progress upload session whose
+// expiry has passed as expired, removes its staged chunks and
+// returns how many sessions were reclaimed, including the
+// finalizing sessions settled by recoverFinalizing.
+func (s *fileService) ExpireSessions(ctx context.Context) (int, error) {
+	now := time.Now()
+	recovered, err := s.recoverFinalizing(ctx, now)
+	if err != nil {
+		return recovered, err
+	}
+	expired, err := s.expireInProgress(ctx, now)
+	return recovered + expired, err
+}
+
+// expireInProgress marks the in-progress sessions expired at now
+// as expired and removes their staged chunks.
+func (s *fileService) expireInProgress(ctx context.Context, now time.Time) (int, error) {
+	cursor, err := s.metadata.Find(ctx, s.staleSessionFilter(now),
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	expired := 0
+	for cursor.Next(ctx) {
+		var session struct {
+			ID string `bson:"_id"`
+		}
+		if err := cursor.Decode(&session); err != nil {
+			return expired, err
+		}
+
+		// Re-check staleness while updating, the session may have
+		// received a chunk or been finalized since it was found.
+		filter := s.staleSessionFilter(now)
+		filter["_id"] = session.ID
+		res, err := s.metadata.UpdateOne(ctx, filter,
+			bson.M{"$set": bson.M{"status": SessionExpired, "updated_at": now}},
+		)
+		if err != nil {
+			return expired, err
+		}
+		if res.ModifiedCount == 0 {
+			continue
+		}
+
+		if err := s.removedProcessedChunks(ctx, session.ID); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, cursor.Err()
+}
+
+// staleSessionFilter matches in-progress sessions expired at now.
+// Sessions created before expiry was tracked expire one TTL after
+// their creation.
+func (s *fileService) staleSessionFilter(now time.Time) bson.M {
+	return bson.M{
+		"status": SessionInProgress,
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$lt": now}},
+			bson.M{
+				"expires_at": bson.M{"$exists": false},
+				"created_at": bson.M{"$lt": now.Add(-s.ttl)},
+			},
+		},
+	}
+}
+
+// recoverFinalizing settles sessions finalizing for longer than the
+// finalize timeout, whose finalize was interrupted by a crash or a
+// lost connection to the database. A session whose file was fully
+// stored is completed with it, any other is failed: its partially
+// written file is deleted and its quota reservation released. The
+// staged chunks are removed in both cases.
+func (s *fileService) recoverFinalizing(ctx context.Context, now time.Time) (int, error) {
+	cursor, err := s.metadata.Find(ctx, s.staleFinalizingFilter(now))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	recovered := 0
+	for cursor.Next(ctx) {
+		var meta UploadMetadata
+		if err := cursor.Decode(&meta); err != nil {
+			return recovered, err
+		}
+		settled, err := s.settleFinalizing(ctx, meta, now)
+		if err != nil {
+			return recovered, err
+		}
+		if !settled {
+			continue
+		}
+		if err := s.removedProcessedChunks(ctx, meta.ID); err != nil {
+			return recovered, err
+		}
+		recovered++
+	}
+	return recovered, cursor.Err()
+}
+
+// settleFinalizing moves a stale finalizing session to completed or
+// failed. It reports false if the session was settled meanwhile.
+func (s *fileService) settleFinalizing(ctx context.Context, meta UploadMetadata, now time.Time) (bool, error) {
+	// Re-check staleness while updating, the finalize may have
+	// completed or failed since the session was found.
+	filter := s.staleFinalizingFilter(now)
+	filter["_id"] = meta.ID
+
+	var info BlobInfo
+	if meta.PendingFileID != "" {
+		var err error
+		info, err = s.blobs.StatByID(ctx, meta.PendingFileID)
+		if err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return false, err
+		}
+	}
+
+	// The digests are stored last, a file carrying them is complete.
+	if info.ID != "" && info.Metadata.SHA256 != "" {
+		res, err := s.metadata.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+			"status":        SessionCompleted,
+			"final_file_id": info.ID,
+			"sha256":        info.Metadata.SHA256,
+			"md5":           info.Metadata.MD5,
+			"updated_at":    now,
+		}})
+		if err != nil || res.ModifiedCount == 0 {
+			return false, err
+		}
+		return true, s.applyRetention(ctx, info)
+	}
+
+	res, err := s.metadata.UpdateOne(ctx, filter, bson.M{"$set": bson.M{
+		"status":     SessionFailed,
+		"error":      "finalize interrupted",
+		"updated_at": now,
+	}})
+	if err != nil || res.ModifiedCount == 0 {
+		return false, err
+	}
+	if meta.PendingFileID != "" {
+		if err := s.blobs.Delete(ctx, meta.PendingFileID); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return true, err
+		}
+	}
+	return true, s.releaseQuota(ctx, meta.Owner, meta.FileSize)
+}
+
+// staleFinalizingFilter matches sessions finalizing for longer than
+// the finalize timeout at now. Sessions claimed before the start of
+// finalizing was recorded are judged by their last update.
+func (s *fileService) staleFinalizingFilter(now time.Time) bson.M {
+	cutoff := now.Add(-s.finalizeTimeout)
+	return bson.M{
+		"status": SessionFinalizing,
+		"$or": bson.A{
+			bson.M{"finalizing_since": bson.M{"$lt": cutoff}},
+			bson.M{
+				"finalizing_since": bson.M{"$exists": false},
+				"updated_at":       bson.M{"$lt": cutoff},
+			},
+		},
+	}
+}
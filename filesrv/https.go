@@ -2,58 +2,209 @@ package filesrv
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/transport"
 	kitHttp "github.com/go-kit/kit/transport/http"
 	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func MakeHTTPHandler(e Endpoints, logger log.Logger) http.Handler {
+// contextKey is the type of context keys set by the HTTP transport.
+type contextKey int
+
+const (
+	// httpRequestContextKey holds the incoming *http.Request.
+	httpRequestContextKey contextKey = iota
+
+	// apiKeyContextKey holds the API key sent with the request.
+	apiKeyContextKey
+
+	// requestedTenantContextKey holds the tenant named by header or host.
+	requestedTenantContextKey
+
+	// signatureErrorContextKey holds the error of an invalid pre-signed URL
+	// or upload grant.
+	signatureErrorContextKey
+)
+
+// MakeHTTPHandler exposes the endpoints over HTTP. Pre-signed download URLs
+// and upload grants are verified with signer, which may be nil to reject
+// them. The tenant named by a request is found with tenants. Requests to
+// the API routes are recorded in m.
+func MakeHTTPHandler(e Endpoints, signer *URLSigner, tenants TenantResolver, m HTTPMetrics, logger log.Logger) http.Handler {
 	mux := http.NewServeMux()
+	handle := func(route string, h http.Handler) {
+		mux.Handle(route, instrumentHTTP(m, route, h))
+	}
 
 	options := []kitHttp.ServerOption{
 		kitHttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kitHttp.ServerErrorEncoder(makeErrorEncoder(logger)),
+		kitHttp.ServerBefore(kitjwt.HTTPToContext(), apiKeyToContext, tenantToContext(tenants)),
 	}
 
-	mux.Handle("/init-upload", kitHttp.NewServer(
+	handle("/init-upload", kitHttp.NewServer(
 		e.InitUpload,
 		decodeInitUploadRequest,
 		encodeResponse,
 		options...,
 	))
 
-	mux.Handle("/upload-chunk", kitHttp.NewServer(
+	handle("/upload-chunk", kitHttp.NewServer(
 		e.UploadChunk,
 		decodeUploadChunkRequest,
 		encodeResponse,
-		options...,
+		append(options, kitHttp.ServerBefore(verifyUploadGrant(signer)))...,
 	))
 
-	mux.Handle("/finalize-upload", kitHttp.NewServer(
+	handle("/finalize-upload", kitHttp.NewServer(
 		e.FinalizeUpload,
 		decodeFinalizeRequest,
 		encodeResponse,
+		append(options, kitHttp.ServerBefore(verifyUploadGrant(signer)))...,
+	))
+
+	handle("/sign-upload", kitHttp.NewServer(
+		e.SignUpload,
+		decodeSignUploadRequest,
+		encodeResponse,
 		options...,
 	))
 
-	mux.Handle("/abort-upload", kitHttp.NewServer(
+	handle("/abort-upload", kitHttp.NewServer(
 		e.AbortUpload,
 		decodeAbortRequest,
 		encodeResponse,
 		options...,
 	))
 
-	mux.Handle("/download", kitHttp.NewServer(
+	handle("/upload-status", kitHttp.NewServer(
+		e.UploadStatus,
+		decodeUploadStatusRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/download", kitHttp.NewServer(
 		e.Download,
 		decodeDownloadRequest,
 		encodeDownloadResponse,
+		append(options, kitHttp.ServerBefore(populateHTTPRequest, verifySignedDownload(signer)))...,
+	))
+
+	handle("/sign-download", kitHttp.NewServer(
+		e.SignDownload,
+		decodeSignDownloadRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/files", kitHttp.NewServer(
+		e.ListFiles,
+		decodeListFilesRequest,
+		encodeResponse,
 		options...,
 	))
 
+	handle("/trash-file", kitHttp.NewServer(
+		e.TrashFile,
+		decodeFileIDRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/restore-file", kitHttp.NewServer(
+		e.RestoreFile,
+		decodeFileIDRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/purge-file", kitHttp.NewServer(
+		e.PurgeFile,
+		decodeFileIDRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/quota", kitHttp.NewServer(
+		e.GetQuota,
+		decodeQuotaRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/set-quota", kitHttp.NewServer(
+		e.SetQuota,
+		decodeSetQuotaRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/revisions", kitHttp.NewServer(
+		e.ListRevisions,
+		decodeListRevisionsRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/prune-revisions", kitHttp.NewServer(
+		e.PruneRevisions,
+		decodePruneRevisionsRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/download-by-id", kitHttp.NewServer(
+		e.DownloadByID,
+		decodeFileIDRequest,
+		encodeDownloadResponse,
+		append(options, kitHttp.ServerBefore(populateHTTPRequest))...,
+	))
+
+	handle("/file-info", kitHttp.NewServer(
+		e.FileInfo,
+		decodeFileIDRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/grant-access", kitHttp.NewServer(
+		e.GrantAccess,
+		decodeAccessRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/revoke-access", kitHttp.NewServer(
+		e.RevokeAccess,
+		decodeAccessRequest,
+		encodeResponse,
+		options...,
+	))
+
+	handle("/set-public", kitHttp.NewServer(
+		e.SetPublic,
+		decodePublicRequest,
+		encodeResponse,
+		options...,
+	))
+
+	// Metrics registered with the default Prometheus registry.
+	mux.Handle("/metrics", promhttp.Handler())
+
 	// ✅ Register HTML UI route on correct mux
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "./index.html")
@@ -64,27 +215,45 @@ func MakeHTTPHandler(e Endpoints, logger log.Logger) http.Handler {
 
 func decodeInitUploadRequest(_ context.Context, r *http.Request) (any, error) {
 	var req InitUploadRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
-	return req, err
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, withDetails(ErrInvalidRequest, err.Error())
+	}
+	return req, nil
 }
 
-func decodeUploadChunkRequest(_ context.Context, r *http.Request) (any, error) {
+func decodeUploadChunkRequest(ctx context.Context, r *http.Request) (any, error) {
+	if err, ok := ctx.Value(signatureErrorContextKey).(error); ok {
+		return nil, err
+	}
 	sessionID := r.URL.Query().Get("session_id")
-	chunkNum, _ := strconv.Atoi(r.URL.Query().Get("chunk"))
+	chunkNum, err := strconv.Atoi(r.URL.Query().Get("chunk"))
+	if err != nil {
+		return nil, withDetails(ErrInvalidRequest, "chunk must be an integer")
+	}
 
-	data, err := io.ReadAll(r.Body)
+	rawChecksum := r.Header.Get("X-Chunk-Checksum")
+	if rawChecksum == "" {
+		rawChecksum = r.URL.Query().Get("checksum")
+	}
+	checksum, err := ParseChunkChecksum(rawChecksum)
 	if err != nil {
 		return nil, err
 	}
 
+	// The body is streamed into staging by the service rather than read
+	// into memory here; it stays open until the handler returns.
 	return UploadChunkRequest{
 		SessionID: sessionID,
 		ChunkNum:  chunkNum,
-		Data:      data,
+		Checksum:  checksum,
+		Data:      r.Body,
 	}, nil
 }
 
-func decodeFinalizeRequest(_ context.Context, r *http.Request) (any, error) {
+func decodeFinalizeRequest(ctx context.Context, r *http.Request) (any, error) {
+	if err, ok := ctx.Value(signatureErrorContextKey).(error); ok {
+		return nil, err
+	}
 	return FinalizeRequest{
 		SessionID: r.URL.Query().Get("session_id"),
 	}, nil
@@ -96,29 +265,422 @@ func decodeAbortRequest(_ context.Context, r *http.Request) (any, error) {
 	}, nil
 }
 
-func encodeResponse(ctx context.Context, w http.ResponseWriter, response any) error {
+func decodeUploadStatusRequest(_ context.Context, r *http.Request) (any, error) {
+	return UploadStatusRequest{
+		SessionID: r.URL.Query().Get("session_id"),
+	}, nil
+}
+
+// encodeResponse writes the response as JSON. A response carrying an
+// error is handed back to the server, which logs it and writes it with
+// the error encoder.
+func encodeResponse(_ context.Context, w http.ResponseWriter, response any) error {
 	if errResp, ok := response.(errorer); ok && errResp.Err() != nil {
-		http.Error(w, errResp.Err().Error(), http.StatusInternalServerError)
-		return nil
+		return errResp.Err()
 	}
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(response)
 }
 
-func decodeDownloadRequest(_ context.Context, r *http.Request) (any, error) {
+// errorMapping associates a service error with its HTTP status code and
+// the code reported in the error response.
+type errorMapping struct {
+	err    error  // sentinel error matched with errors.Is
+	status int    // HTTP status code
+	code   string // machine readable error code
+}
+
+// errorMappings lists the service errors known to the transport. Errors
+// not listed are reported as internal server errors.
+var errorMappings = []errorMapping{
+	{ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{ErrInvalidChecksum, http.StatusBadRequest, "invalid_checksum"},
+	{ErrInvalidRevision, http.StatusBadRequest, "invalid_revision"},
+	{ErrInvalidRetention, http.StatusBadRequest, "invalid_retention"},
+	{ErrInvalidListQuery, http.StatusBadRequest, "invalid_list_query"},
+	{ErrChunkOutOfRange, http.StatusBadRequest, "chunk_out_of_range"},
+	{ErrChunkSizeMismatch, http.StatusBadRequest, "chunk_size_mismatch"},
+	{ErrUnauthenticated, http.StatusUnauthorized, "unauthenticated"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrInvalidSignature, http.StatusForbidden, "invalid_signature"},
+	{ErrSigningDisabled, http.StatusNotImplemented, "signing_disabled"},
+	{ErrTenantRequired, http.StatusBadRequest, "tenant_required"},
+	{ErrUnknownTenant, http.StatusNotFound, "unknown_tenant"},
+	{ErrBlobNotFound, http.StatusNotFound, "file_not_found"},
+	{ErrSessionNotFound, http.StatusNotFound, "session_not_found"},
+	{ErrSessionNotInProgress, http.StatusConflict, "session_not_in_progress"},
+	{ErrIncompleteUpload, http.StatusConflict, "incomplete_upload"},
+	{ErrChunkNotFound, http.StatusConflict, "chunk_not_staged"},
+	{ErrInvalidFileSize, http.StatusBadRequest, "invalid_file_size"},
+	{ErrChunkTooLarge, http.StatusRequestEntityTooLarge, "chunk_too_large"},
+	{ErrFileTooLarge, http.StatusRequestEntityTooLarge, "file_too_large"},
+	{ErrFileSizeExceeded, http.StatusRequestEntityTooLarge, "file_size_exceeded"},
+	{ErrStagingLimitExceeded, http.StatusRequestEntityTooLarge, "staging_limit_exceeded"},
+	{ErrChecksumMismatch, http.StatusUnprocessableEntity, "checksum_mismatch"},
+	{ErrQuotaExceeded, http.StatusInsufficientStorage, "quota_exceeded"},
+}
+
+// makeErrorEncoder returns an error encoder writing err as an
+// ErrorResponse with the status code matching it. The message is the
+// one of the matched service error, so errors joined to it, such as a
+// failure to close a file, are not exposed; neither is the message of
+// unknown errors. Both are logged by the server error handler instead.
+// Failures to write the response are logged with logger.
+func makeErrorEncoder(logger log.Logger) kitHttp.ErrorEncoder {
+	return func(_ context.Context, err error, w http.ResponseWriter) {
+		status, resp := http.StatusInternalServerError, ErrorResponse{
+			Code:    "internal_error",
+			Message: http.StatusText(http.StatusInternalServerError),
+		}
+		for _, m := range errorMappings {
+			if errors.Is(err, m.err) {
+				status = m.status
+				resp = ErrorResponse{Code: m.code, Message: m.err.Error()}
+				break
+			}
+		}
+
+		var detailed *Error
+		if errors.As(err, &detailed) {
+			resp.Details = detailed.Details
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="file-mgmt-srv"`)
+		}
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			_ = logger.Log("msg", "failed to encode error response", "err", err)
+		}
+	}
+}
+
+// decodeDownloadRequest reads the file to download by filename and revision,
+// by file_id, or from the pre-signed URL verified by verifySignedDownload.
+func decodeDownloadRequest(ctx context.Context, r *http.Request) (any, error) {
+	if err, ok := ctx.Value(signatureErrorContextKey).(error); ok {
+		return nil, err
+	}
+	if signed, ok := SignedDownloadFromContext(ctx); ok {
+		return DownloadRequest{FileID: signed.FileID, Disposition: signed.Disposition}, nil
+	}
+	if fileID := r.URL.Query().Get("file_id"); fileID != "" {
+		return DownloadRequest{FileID: fileID}, nil
+	}
+
 	filename := r.URL.Query().Get("filename")
-	return DownloadRequest{Filename: filename}, nil
+
+	revision := LatestRevision
+	if raw := r.URL.Query().Get("revision"); raw != "" {
+		var err error
+		if revision, err = strconv.Atoi(raw); err != nil {
+			return nil, ErrInvalidRevision
+		}
+	}
+
+	return DownloadRequest{Filename: filename, Revision: revision}, nil
+}
+
+// decodeListFilesRequest parses the filters of /files. Times use RFC 3339
+// and sizes are in bytes.
+func decodeListFilesRequest(_ context.Context, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	query := ListFilesQuery{
+		NamePrefix:  q.Get("prefix"),
+		ContentType: q.Get("content_type"),
+		SortBy:      q.Get("sort"),
+		Cursor:      q.Get("cursor"),
+	}
+
+	if raw := q.Get("trashed"); raw != "" {
+		var err error
+		if query.Trashed, err = strconv.ParseBool(raw); err != nil {
+			return nil, ErrInvalidListQuery
+		}
+	}
+
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return nil, ErrInvalidListQuery
+	}
+
+	var err error
+	for name, dst := range map[string]*time.Time{
+		"uploaded_after":  &query.UploadedAfter,
+		"uploaded_before": &query.UploadedBefore,
+	} {
+		if raw := q.Get(name); raw != "" {
+			if *dst, err = time.Parse(time.RFC3339, raw); err != nil {
+				return nil, ErrInvalidListQuery
+			}
+		}
+	}
+	for name, dst := range map[string]*int64{
+		"min_size": &query.MinSize,
+		"max_size": &query.MaxSize,
+	} {
+		if raw := q.Get(name); raw != "" {
+			if *dst, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				return nil, ErrInvalidListQuery
+			}
+		}
+	}
+	if raw := q.Get("limit"); raw != "" {
+		if query.Limit, err = strconv.Atoi(raw); err != nil {
+			return nil, ErrInvalidListQuery
+		}
+	}
+
+	return query, nil
+}
+
+func decodeListRevisionsRequest(_ context.Context, r *http.Request) (any, error) {
+	return ListRevisionsRequest{Filename: r.URL.Query().Get("filename")}, nil
+}
+
+func decodePruneRevisionsRequest(_ context.Context, r *http.Request) (any, error) {
+	keep, err := strconv.Atoi(r.URL.Query().Get("keep"))
+	if err != nil {
+		return nil, ErrInvalidRetention
+	}
+	return PruneRevisionsRequest{
+		Filename: r.URL.Query().Get("filename"),
+		Keep:     keep,
+	}, nil
+}
+
+func decodeFileIDRequest(_ context.Context, r *http.Request) (any, error) {
+	return FileIDRequest{FileID: r.URL.Query().Get("file_id")}, nil
+}
+
+func decodeQuotaRequest(_ context.Context, r *http.Request) (any, error) {
+	return QuotaRequest{Owner: r.URL.Query().Get("owner")}, nil
+}
+
+// decodeSetQuotaRequest reads the owner and the new limits, max_bytes and
+// max_files, of a quota. Omitted limits are disabled.
+func decodeSetQuotaRequest(_ context.Context, r *http.Request) (any, error) {
+	quota := QuotaRequest{Owner: r.URL.Query().Get("owner")}
+
+	q := r.URL.Query()
+	for name, dst := range map[string]*int64{
+		"max_bytes": &quota.MaxBytes,
+		"max_files": &quota.MaxFiles,
+	} {
+		if raw := q.Get(name); raw != "" {
+			var err error
+			if *dst, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				return nil, withDetails(ErrInvalidRequest, name+" must be an integer")
+			}
+		}
+	}
+	return quota, nil
+}
+
+// decodeAccessRequest reads the file, the grantee, user or group, and the
+// comma separated permissions of an ACL change.
+func decodeAccessRequest(_ context.Context, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	req := AccessRequest{
+		FileID: q.Get("file_id"),
+		Grant: Grant{
+			Principal: q.Get("user"),
+			Group:     q.Get("group"),
+		},
+	}
+	for _, p := range strings.Split(q.Get("permissions"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			req.Permissions = append(req.Permissions, strings.ToLower(p))
+		}
+	}
+	return req, nil
+}
+
+func decodePublicRequest(_ context.Context, r *http.Request) (any, error) {
+	public, err := strconv.ParseBool(r.URL.Query().Get("public"))
+	if err != nil {
+		return nil, withDetails(ErrInvalidRequest, "public must be true or false")
+	}
+	return PublicRequest{FileID: r.URL.Query().Get("file_id"), Public: public}, nil
 }
 
 func encodeDownloadResponse(ctx context.Context, w http.ResponseWriter, response any) error {
 	resp, ok := response.(DownloadResponse)
 	if !ok {
-		http.Error(w, "invalid response type", http.StatusInternalServerError)
+		return errors.New("invalid response type")
+	}
+	defer resp.Content.Close()
+
+	disposition := resp.Disposition
+	if disposition == "" {
+		// FormatMediaType quotes the filename and encodes non-ASCII names
+		// as filename*, so the header cannot be broken by the name.
+		disposition = mime.FormatMediaType("attachment", map[string]string{"filename": resp.Info.Filename})
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	contentType := resp.Info.Metadata.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	setDigestHeaders(w.Header(), resp.Info.Metadata)
+
+	// Large files take longer to send than the server write timeout
+	// allows, so the download is not bounded by it. Writers that cannot
+	// clear the deadline keep it.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// ServeContent answers Range and If-Range requests with 206 Partial
+	// Content, including multipart byteranges, and sets Accept-Ranges.
+	if r, ok := ctx.Value(httpRequestContextKey).(*http.Request); ok {
+		http.ServeContent(w, r, resp.Info.Filename, resp.Info.UploadDate, resp.Content)
 		return nil
 	}
 
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+resp.Filename+"\"")
-	w.Header().Set("Content-Type", "application/octet-stream")
-	_, err := w.Write(resp.Data)
+	w.Header().Set("Content-Length", strconv.FormatInt(resp.Info.Length, 10))
+	_, err := io.Copy(w, resp.Content)
 	return err
 }
+
+// setDigestHeaders advertises the stored content hashes using the
+// Digest header (RFC 3230) and a strong ETag derived from the SHA-256.
+func setDigestHeaders(h http.Header, meta BlobMetadata) {
+	var digests []string
+	if sum, err := hex.DecodeString(meta.SHA256); err == nil && len(sum) > 0 {
+		digests = append(digests, "sha-256="+base64.StdEncoding.EncodeToString(sum))
+		h.Set("ETag", `"`+meta.SHA256+`"`)
+	}
+	if sum, err := hex.DecodeString(meta.MD5); err == nil && len(sum) > 0 {
+		digests = append(digests, "md5="+base64.StdEncoding.EncodeToString(sum))
+	}
+	if len(digests) > 0 {
+		h.Set("Digest", strings.Join(digests, ","))
+	}
+}
+
+// populateHTTPRequest stores the incoming request in the context so that
+// response encoders can honour request headers such as Range.
+func populateHTTPRequest(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, httpRequestContextKey, r)
+}
+
+// tenantToContext returns a request function storing the tenant named by
+// the request on the context, for ResolveTenant to check.
+func tenantToContext(tenants TenantResolver) kitHttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if tenant := tenants.Resolve(r); tenant != "" {
+			return context.WithValue(ctx, requestedTenantContextKey, tenant)
+		}
+		return ctx
+	}
+}
+
+// verifySignedDownload returns a request function verifying the signature
+// of pre-signed download URLs. A valid URL is stored on the context as a
+// SignedDownload, an invalid one as error for the decoder to return.
+// Requests without signature are left to the authenticators.
+func verifySignedDownload(signer *URLSigner) kitHttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if !r.URL.Query().Has(signatureParam) {
+			return ctx
+		}
+		remoteIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			remoteIP = r.RemoteAddr
+		}
+		signed, err := signer.VerifyDownloadURL(r.URL.Query(), remoteIP, time.Now())
+		if err != nil {
+			return context.WithValue(ctx, signatureErrorContextKey, err)
+		}
+		return ContextWithSignedDownload(ctx, signed)
+	}
+}
+
+// decodeSignDownloadRequest reads the file_id and the optional ttl (a
+// duration such as "10m"), ip and disposition of a pre-signed download URL.
+func decodeSignDownloadRequest(_ context.Context, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	req := SignDownloadRequest{
+		FileID: q.Get("file_id"),
+		DownloadURLOptions: DownloadURLOptions{
+			ClientIP:    q.Get("ip"),
+			Disposition: q.Get("disposition"),
+		},
+	}
+	if raw := q.Get("ttl"); raw != "" {
+		var err error
+		if req.TTL, err = time.ParseDuration(raw); err != nil {
+			return nil, withDetails(ErrInvalidRequest, "ttl must be a duration such as 10m")
+		}
+	}
+	return req, nil
+}
+
+// verifyUploadGrant returns a request function verifying the upload grant
+// sent in the X-Upload-Token header, or the upload_token query parameter.
+// The grant must be for the session of the request; its size limit is
+// enforced against the file size the session declares. A valid grant is
+// stored on the context, an invalid one as error for the decoder to
+// return.
+func verifyUploadGrant(signer *URLSigner) kitHttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		token := r.Header.Get("X-Upload-Token")
+		if token == "" {
+			token = r.URL.Query().Get("upload_token")
+		}
+		if token == "" {
+			return ctx
+		}
+
+		grant, err := signer.VerifyUploadGrant(token, time.Now())
+		switch {
+		case err != nil:
+		case grant.SessionID != r.URL.Query().Get("session_id"):
+			err = withDetails(ErrForbidden, "upload grant is for another session")
+		}
+		if err != nil {
+			return context.WithValue(ctx, signatureErrorContextKey, err)
+		}
+		return ContextWithUploadGrant(ctx, grant)
+	}
+}
+
+// decodeSignUploadRequest reads the session_id and the optional ttl (a
+// duration such as "1h"), max_file_size and content_type of an upload
+// grant.
+func decodeSignUploadRequest(_ context.Context, r *http.Request) (any, error) {
+	q := r.URL.Query()
+	req := SignUploadRequest{
+		SessionID: q.Get("session_id"),
+		UploadGrantOptions: UploadGrantOptions{
+			ContentType: q.Get("content_type"),
+		},
+	}
+	if raw := q.Get("ttl"); raw != "" {
+		var err error
+		if req.TTL, err = time.ParseDuration(raw); err != nil {
+			return nil, withDetails(ErrInvalidRequest, "ttl must be a duration such as 1h")
+		}
+	}
+	if raw := q.Get("max_file_size"); raw != "" {
+		var err error
+		if req.MaxFileSize, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			return nil, withDetails(ErrInvalidRequest, "max_file_size must be an integer")
+		}
+	}
+	return req, nil
+}
+
+// apiKeyToContext stores the API key of the X-API-Key header in the
+// context for the API key authenticator.
+func apiKeyToContext(ctx context.Context, r *http.Request) context.Context {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return context.WithValue(ctx, apiKeyContextKey, key)
+	}
+	return ctx
+}
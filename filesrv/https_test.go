@@ -0,0 +1,88 @@
+package filesrv
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowContent delays its first read past the server write timeout.
+type slowContent struct {
+	r     *bytes.Reader // content, not embedded so that io.Copy calls Read
+	delay time.Duration // delay before the first read
+}
+
+func (c *slowContent) Read(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	c.delay = 0
+	return c.r.Read(p)
+}
+
+func (c *slowContent) Seek(offset int64, whence int) (int64, error) {
+	return c.r.Seek(offset, whence)
+}
+
+func (c *slowContent) Close() error { return nil }
+
+func TestEncodeDownloadResponseWriteTimeout(t *testing.T) {
+	body := []byte("slow download")
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		resp := DownloadResponse{
+			Info:    BlobInfo{Filename: "slow.txt", Length: int64(len(body))},
+			Content: &slowContent{r: bytes.NewReader(body), delay: 200 * time.Millisecond},
+		}
+		if err := encodeDownloadResponse(context.Background(), &statusRecorder{ResponseWriter: w, status: http.StatusOK}, resp); err != nil {
+			t.Errorf("encodeDownloadResponse: %v", err)
+		}
+	}))
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer res.Body.Close()
+	got, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("body = %q, want %q", got, body)
+	}
+}
+
+func TestEncodeDownloadResponseDisposition(t *testing.T) {
+	tests := []struct {
+		name        string
+		filename    string
+		disposition string
+		want        string
+	}{
+		{name: "plain", filename: "report.pdf", want: "attachment; filename=report.pdf"},
+		{name: "quotes", filename: `a"b.txt`, want: `attachment; filename="a\"b.txt"`},
+		{name: "non-ascii", filename: "résumé.txt", want: "attachment; filename*=utf-8''r%C3%A9sum%C3%A9.txt"},
+		{name: "override", filename: "report.pdf", disposition: "inline", want: "inline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			resp := DownloadResponse{
+				Info:        BlobInfo{Filename: tt.filename},
+				Content:     &slowContent{r: bytes.NewReader(nil)},
+				Disposition: tt.disposition,
+			}
+			if err := encodeDownloadResponse(context.Background(), w, resp); err != nil {
+				t.Fatalf("encodeDownloadResponse: %v", err)
+			}
+			if got := w.Header().Get("Content-Disposition"); got != tt.want {
+				t.Errorf("Content-Disposition = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+// Package filesrv provides middleware recording metrics of the operations
+// of the FileService and of the HTTP requests serving them using Go-Kit's
+// metrics abstractions, such as request counts, latencies and transferred
+// bytes.
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// Metrics are the instruments updated by the instrumenting middleware.
+// Requests and RequestLatency are labelled with "method" and "outcome",
+// the error code of a failed call or "success".
+type Metrics struct {
+	Requests           metrics.Counter   // Calls per method and outcome
+	RequestLatency     metrics.Histogram // Call duration in seconds per method and outcome
+	BytesUploaded      metrics.Counter   // Bytes of accepted chunks
+	BytesDownloaded    metrics.Counter   // Bytes read from downloaded files
+	ActiveSessions     metrics.Gauge     // Unfinished upload sessions, sampled by GetStats
+	RecordedChunkBytes metrics.Gauge     // Chunk lengths recorded in unfinished sessions, sampled by GetStats
+	FinalizeDuration   metrics.Histogram // Duration in seconds of successful finalize calls
+}
+
+// HTTPMetrics are the instruments updated for every request to an API
+// route, including requests rejected before reaching the FileService
+// such as unauthenticated or malformed ones. Both are labelled with
+// "route" and the status "code" of the response.
+type HTTPMetrics struct {
+	Requests       metrics.Counter   // Requests per route and status code
+	RequestLatency metrics.Histogram // Request duration in seconds per route and status code
+}
+
+// InstrumentingMiddleware returns a middleware that records the count,
+// latency and outcome of each method call, the bytes uploaded and
+// downloaded, and the session gauges reported by GetStats.
+func InstrumentingMiddleware(m Metrics) Middleware {
+	return func(next FileService) FileService {
+		return &instrumentingMiddleware{
+			next:    next,
+			metrics: m,
+		}
+	}
+}
+
+// instrumentingMiddleware is a FileService implementation that wraps
+// another FileService and records metrics about each method invocation.
+type instrumentingMiddleware struct {
+	next    FileService // the next service in the chain
+	metrics Metrics     // instruments to update
+}
+
+// observe records a call of method started at begin that failed with err.
+func (mw instrumentingMiddleware) observe(method string, begin time.Time, err error) {
+	lvs := []string{"method", method, "outcome", outcome(err)}
+	mw.metrics.Requests.With(lvs...).Add(1)
+	mw.metrics.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+}
+
+// outcome names the result of a call by the error code the transport
+// reports for err, keeping the label values bounded.
+func outcome(err error) string {
+	if err == nil {
+		return "success"
+	}
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			return m.code
+		}
+	}
+	return "internal_error"
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader // underlying stream
+	n int64     // bytes read so far
+}
+
+// Read reads from the underlying stream and counts the bytes.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingBlobReader adds the bytes read from a downloaded file to a
+// counter as they are sent.
+type countingBlobReader struct {
+	BlobReader
+	bytes metrics.Counter // counter of downloaded bytes
+}
+
+// Read reads from the file and counts the bytes.
+func (c countingBlobReader) Read(p []byte) (int, error) {
+	n, err := c.BlobReader.Read(p)
+	c.bytes.Add(float64(n))
+	return n, err
+}
+
+// InitUpload records metrics for InitUpload calls.
+func (mw instrumentingMiddleware) InitUpload(ctx context.Context, filename string, totalChunks int, chunkSize int, fileSize int64, contentType string, keepRevisions int) (id string, err error) {
+	defer func(begin time.Time) { mw.observe("InitUpload", begin, err) }(time.Now())
+	return mw.next.InitUpload(ctx, filename, totalChunks, chunkSize, fileSize, contentType, keepRevisions)
+}
+
+// UploadChunk records metrics for UploadChunk calls and counts the bytes
+// of accepted chunks.
+func (mw instrumentingMiddleware) UploadChunk(ctx context.Context, sessionID string, chunkNum int, checksum ChunkChecksum, data io.Reader) (err error) {
+	counted := &countingReader{r: data}
+	defer func(begin time.Time) {
+		mw.observe("UploadChunk", begin, err)
+		if err == nil {
+			mw.metrics.BytesUploaded.Add(float64(counted.n))
+		}
+	}(time.Now())
+	return mw.next.UploadChunk(ctx, sessionID, chunkNum, checksum, counted)
+}
+
+// FinalizeUpload records metrics for FinalizeUpload calls and the
+// duration of the successful ones.
+func (mw instrumentingMiddleware) FinalizeUpload(ctx context.Context, sessionID string) (info BlobInfo, err error) {
+	defer func(begin time.Time) {
+		mw.observe("FinalizeUpload", begin, err)
+		if err == nil {
+			mw.metrics.FinalizeDuration.Observe(time.Since(begin).Seconds())
+		}
+	}(time.Now())
+	return mw.next.FinalizeUpload(ctx, sessionID)
+}
+
+// AbortUpload records metrics for AbortUpload calls.
+func (mw instrumentingMiddleware) AbortUpload(ctx context.Context, sessionID string) (err error) {
+	defer func(begin time.Time) { mw.observe("AbortUpload", begin, err) }(time.Now())
+	return mw.next.AbortUpload(ctx, sessionID)
+}
+
+// GetUploadStatus records metrics for GetUploadStatus calls.
+func (mw instrumentingMiddleware) GetUploadStatus(ctx context.Context, sessionID string) (status UploadStatus, err error) {
+	defer func(begin time.Time) { mw.observe("GetUploadStatus", begin, err) }(time.Now())
+	return mw.next.GetUploadStatus(ctx, sessionID)
+}
+
+// ExpireSessions records metrics for ExpireSessions calls.
+func (mw instrumentingMiddleware) ExpireSessions(ctx context.Context) (expired int, err error) {
+	defer func(begin time.Time) { mw.observe("ExpireSessions", begin, err) }(time.Now())
+	return mw.next.ExpireSessions(ctx)
+}
+
+// ReconcileStaging records metrics for ReconcileStaging calls.
+func (mw instrumentingMiddleware) ReconcileStaging(ctx context.Context) (report ReconcileReport, err error) {
+	defer func(begin time.Time) { mw.observe("ReconcileStaging", begin, err) }(time.Now())
+	return mw.next.ReconcileStaging(ctx)
+}
+
+// GetStats records metrics for GetStats calls and updates the session
+// gauges from the reported stats.
+func (mw instrumentingMiddleware) GetStats(ctx context.Context) (stats Stats, err error) {
+	defer func(begin time.Time) {
+		mw.observe("GetStats", begin, err)
+		if err == nil {
+			mw.metrics.ActiveSessions.Set(float64(stats.ActiveSessions))
+			mw.metrics.RecordedChunkBytes.Set(float64(stats.StagedBytes))
+		}
+	}(time.Now())
+	return mw.next.GetStats(ctx)
+}
+
+// DownloadFile records metrics for DownloadFile calls and counts the
+// bytes sent.
+func (mw instrumentingMiddleware) DownloadFile(ctx context.Context, filename string, revision int) (file BlobReader, err error) {
+	defer func(begin time.Time) { mw.observe("DownloadFile", begin, err) }(time.Now())
+	file, err = mw.next.DownloadFile(ctx, filename, revision)
+	if err != nil {
+		return nil, err
+	}
+	return countingBlobReader{BlobReader: file, bytes: mw.metrics.BytesDownloaded}, nil
+}
+
+// ListFiles records metrics for ListFiles calls.
+func (mw instrumentingMiddleware) ListFiles(ctx context.Context, query ListFilesQuery) (page ListFilesPage, err error) {
+	defer func(begin time.Time) { mw.observe("ListFiles", begin, err) }(time.Now())
+	return mw.next.ListFiles(ctx, query)
+}
+
+// TrashFile records metrics for TrashFile calls.
+func (mw instrumentingMiddleware) TrashFile(ctx context.Context, fileID string) (info BlobInfo, err error) {
+	defer func(begin time.Time) { mw.observe("TrashFile", begin, err) }(time.Now())
+	return mw.next.TrashFile(ctx, fileID)
+}
+
+// RestoreFile records metrics for RestoreFile calls.
+func (mw instrumentingMiddleware) RestoreFile(ctx context.Context, fileID string) (info BlobInfo, err error) {
+	defer func(begin time.Time) { mw.observe("RestoreFile", begin, err) }(time.Now())
+	return mw.next.RestoreFile(ctx, fileID)
+}
+
+// PurgeFile records metrics for PurgeFile calls.
+func (mw instrumentingMiddleware) PurgeFile(ctx context.Context, fileID string) (err error) {
+	defer func(begin time.Time) { mw.observe("PurgeFile", begin, err) }(time.Now())
+	return mw.next.PurgeFile(ctx, fileID)
+}
+
+// PurgeTrash records metrics for PurgeTrash calls.
+func (mw instrumentingMiddleware) PurgeTrash(ctx context.Context, olderThan time.Time) (purged int, err error) {
+	defer func(begin time.Time) { mw.observe("PurgeTrash", begin, err) }(time.Now())
+	return mw.next.PurgeTrash(ctx, olderThan)
+}
+
+// GetQuota records metrics for GetQuota calls.
+func (mw instrumentingMiddleware) GetQuota(ctx context.Context, owner string) (usage QuotaUsage, err error) {
+	defer func(begin time.Time) { mw.observe("GetQuota", begin, err) }(time.Now())
+	return mw.next.GetQuota(ctx, owner)
+}
+
+// SetQuota records metrics for SetQuota calls.
+func (mw instrumentingMiddleware) SetQuota(ctx context.Context, owner string, quota Quota) (usage QuotaUsage, err error) {
+	defer func(begin time.Time) { mw.observe("SetQuota", begin, err) }(time.Now())
+	return mw.next.SetQuota(ctx, owner, quota)
+}
+
+// ListRevisions records metrics for ListRevisions calls.
+func (mw instrumentingMiddleware) ListRevisions(ctx context.Context, filename string) (revisions []FileRevision, err error) {
+	defer func(begin time.Time) { mw.observe("ListRevisions", begin, err) }(time.Now())
+	return mw.next.ListRevisions(ctx, filename)
+}
+
+// PruneRevisions records metrics for PruneRevisions calls.
+func (mw instrumentingMiddleware) PruneRevisions(ctx context.Context, filename string, keep int) (deleted []string, err error) {
+	defer func(begin time.Time) { mw.observe("PruneRevisions", begin, err) }(time.Now())
+	return mw.next.PruneRevisions(ctx, filename, keep)
+}
+
+// DownloadFileByID records metrics for DownloadFileByID calls and counts
+// the bytes sent.
+func (mw instrumentingMiddleware) DownloadFileByID(ctx context.Context, fileID string) (file BlobReader, err error) {
+	defer func(begin time.Time) { mw.observe("DownloadFileByID", begin, err) }(time.Now())
+	file, err = mw.next.DownloadFileByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	return countingBlobReader{BlobReader: file, bytes: mw.metrics.BytesDownloaded}, nil
+}
+
+// GetFileInfo records metrics for GetFileInfo calls.
+func (mw instrumentingMiddleware) GetFileInfo(ctx context.Context, fileID string) (info BlobInfo, err error) {
+	defer func(begin time.Time) { mw.observe("GetFileInfo", begin, err) }(time.Now())
+	return mw.next.GetFileInfo(ctx, fileID)
+}
+
+// GrantAccess records metrics for GrantAccess calls.
+func (mw instrumentingMiddleware) GrantAccess(ctx context.Context, fileID string, grant Grant) (info BlobInfo, err error) {
+	defer func(begin time.Time) { mw.observe("GrantAccess", begin, err) }(time.Now())
+	return mw.next.GrantAccess(ctx, fileID, grant)
+}
+
+// RevokeAccess records metrics for RevokeAccess calls.
+func (mw instrumentingMiddleware) RevokeAccess(ctx context.Context, fileID string, grant Grant) (info BlobInfo, err error) {
+	defer func(begin time.Time) { mw.observe("RevokeAccess", begin, err) }(time.Now())
+	return mw.next.RevokeAccess(ctx, fileID, grant)
+}
+
+// SetPublic records metrics for SetPublic calls.
+func (mw instrumentingMiddleware) SetPublic(ctx context.Context, fileID string, public bool) (info BlobInfo, err error) {
+	defer func(begin time.Time) { mw.observe("SetPublic", begin, err) }(time.Now())
+	return mw.next.SetPublic(ctx, fileID, public)
+}
+
+// SignDownloadURL records metrics for SignDownloadURL calls.
+func (mw instrumentingMiddleware) SignDownloadURL(ctx context.Context, fileID string, opts DownloadURLOptions) (signed SignedURL, err error) {
+	defer func(begin time.Time) { mw.observe("SignDownloadURL", begin, err) }(time.Now())
+	return mw.next.SignDownloadURL(ctx, fileID, opts)
+}
+
+// SignUploadGrant records metrics for SignUploadGrant calls.
+func (mw instrumentingMiddleware) SignUploadGrant(ctx context.Context, sessionID string, opts UploadGrantOptions) (grant SignedUploadGrant, err error) {
+	defer func(begin time.Time) { mw.observe("SignUploadGrant", begin, err) }(time.Now())
+	return mw.next.SignUploadGrant(ctx, sessionID, opts)
+}
+
+// instrumentHTTP wraps the handler of an API route, counting and timing
+// its requests by the status code of the response.
+func instrumentHTTP(m HTTPMetrics, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func(begin time.Time) {
+			lvs := []string{"route", route, "code", strconv.Itoa(rec.status)}
+			m.Requests.With(lvs...).Add(1)
+			m.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+		}(time.Now())
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// statusRecorder remembers the status code written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int  // status code sent, 200 unless written explicitly
+	wroteHeader bool // whether the status code was sent
+}
+
+// WriteHeader records the first status code before sending it.
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends the implicit 200 status code if none was written.
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -2,7 +2,11 @@
 // contract for chunked file upload and download services.
 package filesrv
 
-import "context"
+import (
+	"context"
+	"io"
+	"time"
+)
 
 // errorer is an interface used for transport-level error propagation.
 // Implementations can return a concrete error via Err(), enabling Go-Kit
@@ -21,28 +25,167 @@ type FileService interface {
 	// filename     - the name of the file to be uploaded
 	// totalChunks  - the total number of chunks expected
 	// chunkSize    - the size in bytes of each chunk
-	InitUpload(ctx context.Context, filename string, totalChunks int, chunkSize int) (string, error)
+	// fileSize     - the total size in bytes of the file
+	// contentType  - optional MIME type, detected from the filename if empty
+	// keepRevisions - optional number of revisions of filename to retain
+	//                 once stored, 0 to keep the count of the latest revision
+	InitUpload(ctx context.Context, filename string, totalChunks int, chunkSize int, fileSize int64, contentType string, keepRevisions int) (string, error)
 
 	// UploadChunk stores a chunk of the file associated with a session ID.
+	// The chunk is rejected with ErrChecksumMismatch if it does not match
+	// a non-zero checksum.
 	//
 	// sessionID - ID of the upload session
 	// chunkNum  - the index of this chunk (0-based)
-	// data      - raw binary data of the chunk
-	UploadChunk(ctx context.Context, sessionID string, chunkNum int, data []byte) error
+	// checksum  - optional client supplied digest of the chunk
+	// data      - stream over the raw binary data of the chunk
+	UploadChunk(ctx context.Context, sessionID string, chunkNum int, checksum ChunkChecksum, data io.Reader) error
 
 	// FinalizeUpload assembles all chunks for a session into a complete file
-	// and stores it in GridFS. Returns the ID of the stored file.
+	// and stores it in the blob store. Returns the ID, size and SHA-256/MD5
+	// digests of the stored file.
 	//
 	// sessionID - ID of the upload session
-	FinalizeUpload(ctx context.Context, sessionID string) (string, error)
+	FinalizeUpload(ctx context.Context, sessionID string) (BlobInfo, error)
 
 	// AbortUpload cancels the upload session and deletes any stored chunks.
 	//
 	// sessionID - ID of the upload session
 	AbortUpload(ctx context.Context, sessionID string) error
 
-	// DownloadFile retrieves a complete file by its name from GridFS.
+	// GetUploadStatus reports the received and missing chunks of an
+	// upload session.
+	//
+	// sessionID - the upload session to inspect
+	GetUploadStatus(ctx context.Context, sessionID string) (UploadStatus, error)
+
+	// ExpireSessions marks in-progress upload sessions idle for longer
+	// than the session TTL as expired and removes their staged chunks.
+	// Sessions finalizing for longer than the finalize timeout are
+	// completed if their file was stored, otherwise failed with
+	// their partial file and quota reservation released.
+	// Returns the number of reclaimed sessions.
+	ExpireSessions(ctx context.Context) (int, error)
+
+	// ReconcileStaging brings the staged chunks and the upload metadata
+	// back in line after a crash. It must run before uploads are accepted.
+	ReconcileStaging(ctx context.Context) (ReconcileReport, error)
+
+	// GetStats reports the number of unfinished upload sessions and the
+	// bytes staged for them.
+	GetStats(ctx context.Context) (Stats, error)
+
+	// DownloadFile opens a complete file by its name from the blob store.
+	// The returned reader streams the content and describes the file; the
+	// caller must close it.
 	//
 	// filename - the original name of the file to download
-	DownloadFile(ctx context.Context, filename string) ([]byte, error)
+	// revision - the revision to download, LatestRevision for the newest;
+	//            negative values count back from the newest
+	DownloadFile(ctx context.Context, filename string, revision int) (BlobReader, error)
+
+	// ListFiles returns one page of stored files matching the query. Files
+	// in trash are only listed when the query asks for them.
+	//
+	// query - filters, sort order and pagination cursor
+	ListFiles(ctx context.Context, query ListFilesQuery) (ListFilesPage, error)
+
+	// TrashFile soft deletes a stored file by moving it to trash, recording
+	// the deletion time and the principal from the context.
+	//
+	// fileID - the ID of the stored file
+	TrashFile(ctx context.Context, fileID string) (BlobInfo, error)
+
+	// RestoreFile moves a file out of trash.
+	//
+	// fileID - the ID of the trashed file
+	RestoreFile(ctx context.Context, fileID string) (BlobInfo, error)
+
+	// PurgeFile permanently deletes a file that is in trash.
+	//
+	// fileID - the ID of the trashed file
+	PurgeFile(ctx context.Context, fileID string) error
+
+	// PurgeTrash permanently deletes all files moved to trash before
+	// olderThan. Returns the number of purged files.
+	//
+	// olderThan - files trashed before this time are purged
+	PurgeTrash(ctx context.Context, olderThan time.Time) (int, error)
+
+	// GetQuota returns the storage quota and current usage of an owner.
+	// Only administrators may read the quota of other owners.
+	//
+	// owner - the principal owning the files
+	GetQuota(ctx context.Context, owner string) (QuotaUsage, error)
+
+	// SetQuota changes the storage quota of an owner. Only
+	// administrators may change quotas.
+	//
+	// owner - the principal owning the files
+	// quota - the new limits, zero disables a limit
+	SetQuota(ctx context.Context, owner string, quota Quota) (QuotaUsage, error)
+
+	// ListRevisions returns the stored revisions of filename readable by the
+	// caller, oldest first, numbered over the whole history.
+	//
+	// filename - the original name of the file
+	ListRevisions(ctx context.Context, filename string) ([]FileRevision, error)
+
+	// PruneRevisions deletes the oldest revisions of filename so that at
+	// most keep revisions remain. Returns the IDs of the deleted files.
+	//
+	// filename - the original name of the file
+	// keep     - the number of most recent revisions to retain (at least 1)
+	PruneRevisions(ctx context.Context, filename string, keep int) ([]string, error)
+
+	// DownloadFileByID opens exactly the stored file with the given ID, as
+	// returned by FinalizeUpload. The caller must close the returned reader.
+	//
+	// fileID - the ID of the stored file
+	DownloadFileByID(ctx context.Context, fileID string) (BlobReader, error)
+
+	// GetFileInfo returns the name, size, upload date and digests of the
+	// stored file with the given ID without opening its content.
+	//
+	// fileID - the ID of the stored file
+	GetFileInfo(ctx context.Context, fileID string) (BlobInfo, error)
+
+	// GrantAccess gives a user or group permissions on a stored file. The
+	// caller needs write permission on the file and may only grant
+	// permissions it holds itself.
+	//
+	// fileID - the ID of the stored file
+	// grant  - the grantee and the Permission* constants to add
+	GrantAccess(ctx context.Context, fileID string, grant Grant) (BlobInfo, error)
+
+	// RevokeAccess takes permissions on a stored file away from a user or
+	// group, all of them if grant lists none. The caller needs write
+	// permission and every permission it revokes.
+	//
+	// fileID - the ID of the stored file
+	// grant  - the grantee and the Permission* constants to remove
+	RevokeAccess(ctx context.Context, fileID string, grant Grant) (BlobInfo, error)
+
+	// SetPublic changes whether every authenticated caller may read a
+	// stored file.
+	//
+	// fileID - the ID of the stored file
+	// public - whether the file is readable by everyone
+	SetPublic(ctx context.Context, fileID string, public bool) (BlobInfo, error)
+
+	// SignDownloadURL issues a pre-signed URL downloading a stored file
+	// without credentials until it expires. The caller needs read
+	// permission on the file.
+	//
+	// fileID - the ID of the stored file
+	// opts   - the lifetime, client IP binding and content disposition
+	SignDownloadURL(ctx context.Context, fileID string, opts DownloadURLOptions) (SignedURL, error)
+
+	// SignUploadGrant issues a token letting a client without credentials
+	// upload the chunks of a session and finalize it until the token
+	// expires. The caller must own the session.
+	//
+	// sessionID - the ID of the in-progress upload session
+	// opts      - the lifetime, file size and content type limits
+	SignUploadGrant(ctx context.Context, sessionID string, opts UploadGrantOptions) (SignedUploadGrant, error)
 }
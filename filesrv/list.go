@@ -0,0 +1,183 @@
+// Package filesrv provides the query, sorting and cursor pagination
+// model used to list and search stored files.
+package filesrv
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Fields stored files can be sorted by.
+const (
+	SortByUploadDate = "upload_date" // Sort by upload date (default)
+	SortByFilename   = "filename"    // Sort by filename
+	SortByLength     = "length"      // Sort by size in bytes
+)
+
+const (
+	defaultListLimit = 50   // page size used when no limit is given
+	maxListLimit     = 1000 // largest page size a client may request
+)
+
+// ListFilesQuery filters, sorts and paginates stored files. Zero values
+// disable the corresponding filter.
+type ListFilesQuery struct {
+	NamePrefix      string    // Only files whose name starts with this prefix
+	UploadedAfter   time.Time // Only files uploaded at or after this time
+	UploadedBefore  time.Time // Only files uploaded before this time
+	MinSize         int64     // Only files of at least this many bytes
+	MaxSize         int64     // Only files of at most this many bytes
+	ContentType     string    // Only files of this content type
+	Trashed         bool      // List files in trash instead of live files
+	DeletedBefore   time.Time // Only trashed files deleted before this time
+	ReadableBy      *Identity // Only files this identity may read, nil for all files
+	ReadableUnowned bool      // With ReadableBy, also files without owner
+	SortBy          string    // One of the SortBy* constants
+	Descending      bool      // Sort in descending order
+	Limit           int       // Maximum number of files per page
+	Cursor          string    // Opaque cursor returned by the previous page
+}
+
+// ListFilesPage is one page of stored files.
+type ListFilesPage struct {
+	Files      []BlobInfo `json:"files"`                 // Files on this page
+	NextCursor string     `json:"next_cursor,omitempty"` // Cursor of the next page, empty on the last page
+}
+
+// listCursor is the decoded form of ListFilesQuery.Cursor. It records the
+// sort key of the last file on a page, using the ID as tie breaker.
+type listCursor struct {
+	SortBy     string    `json:"s"`           // sort field the cursor was issued for
+	Descending bool      `json:"d,omitempty"` // sort order the cursor was issued for
+	ID         string    `json:"id"`          // ID of the last file on the page
+	Filename   string    `json:"f,omitempty"` // filename of the last file
+	Length     int64     `json:"l,omitempty"` // length of the last file
+	UploadDate time.Time `json:"u"`           // upload date of the last file
+}
+
+// normalize validates the query and fills in defaults. It returns the
+// decoded cursor, or nil for the first page.
+func (q *ListFilesQuery) normalize() (*listCursor, error) {
+	switch q.SortBy {
+	case "":
+		q.SortBy = SortByUploadDate
+	case SortByUploadDate, SortByFilename, SortByLength:
+	default:
+		return nil, ErrInvalidListQuery
+	}
+
+	switch {
+	case q.Limit == 0:
+		q.Limit = defaultListLimit
+	case q.Limit < 0:
+		return nil, ErrInvalidListQuery
+	case q.Limit > maxListLimit:
+		q.Limit = maxListLimit
+	}
+
+	if q.MinSize < 0 || q.MaxSize < 0 || (q.MaxSize > 0 && q.MinSize > q.MaxSize) {
+		return nil, ErrInvalidListQuery
+	}
+
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidListQuery
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, ErrInvalidListQuery
+	}
+	if cursor.SortBy != q.SortBy || cursor.Descending != q.Descending {
+		return nil, ErrInvalidListQuery
+	}
+	return &cursor, nil
+}
+
+// newListCursor builds the cursor pointing after info for the query.
+func newListCursor(q ListFilesQuery, info BlobInfo) string {
+	raw, _ := json.Marshal(listCursor{
+		SortBy:     q.SortBy,
+		Descending: q.Descending,
+		ID:         info.ID,
+		Filename:   info.Filename,
+		Length:     info.Length,
+		UploadDate: info.UploadDate,
+	})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// matches reports whether info passes the filters of the query.
+func (q ListFilesQuery) matches(info BlobInfo) bool {
+	switch {
+	case q.NamePrefix != "" && !strings.HasPrefix(info.Filename, q.NamePrefix):
+		return false
+	case !q.UploadedAfter.IsZero() && info.UploadDate.Before(q.UploadedAfter):
+		return false
+	case !q.UploadedBefore.IsZero() && !info.UploadDate.Before(q.UploadedBefore):
+		return false
+	case info.Length < q.MinSize:
+		return false
+	case q.MaxSize > 0 && info.Length > q.MaxSize:
+		return false
+	case q.ContentType != "" && info.Metadata.ContentType != q.ContentType:
+		return false
+	case info.Metadata.Trashed() != q.Trashed:
+		return false
+	case !q.DeletedBefore.IsZero() && (!info.Metadata.Trashed() || !info.Metadata.DeletedAt.Before(q.DeletedBefore)):
+		return false
+	case q.ReadableBy != nil && !info.Metadata.Allows(*q.ReadableBy, PermissionRead) &&
+		(!q.ReadableUnowned || info.Metadata.Owner != ""):
+		return false
+	}
+	return true
+}
+
+// compare orders two files by the sort field of the query, then by ID,
+// honouring the sort direction.
+func (q ListFilesQuery) compare(a, b listCursor) int {
+	var c int
+	switch q.SortBy {
+	case SortByFilename:
+		c = strings.Compare(a.Filename, b.Filename)
+	case SortByLength:
+		c = cmp.Compare(a.Length, b.Length)
+	default:
+		c = a.UploadDate.Compare(b.UploadDate)
+	}
+	if c == 0 {
+		c = strings.Compare(a.ID, b.ID)
+	}
+	if q.Descending {
+		return -c
+	}
+	return c
+}
+
+// paginate cuts files, already filtered and sorted, down to one page.
+func paginate(q ListFilesQuery, files []BlobInfo) ListFilesPage {
+	page := ListFilesPage{Files: files}
+	if len(files) > q.Limit {
+		page.Files = files[:q.Limit]
+		page.NextCursor = newListCursor(q, page.Files[q.Limit-1])
+	}
+	if page.Files == nil {
+		page.Files = []BlobInfo{}
+	}
+	return page
+}
+
+// sortKey extracts the fields compare looks at from info.
+func sortKey(info BlobInfo) listCursor {
+	return listCursor{
+		ID:         info.ID,
+		Filename:   info.Filename,
+		Length:     info.Length,
+		UploadDate: info.UploadDate,
+	}
+}
@@ -0,0 +1,100 @@
+package filesrv
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListFilesQueryNormalize(t *testing.T) {
+	uploadDateCursor := newListCursor(ListFilesQuery{SortBy: SortByUploadDate}, BlobInfo{ID: "b1"})
+
+	tests := []struct {
+		name      string
+		query     ListFilesQuery
+		wantLimit int
+		wantErr   error
+	}{
+		{name: "default limit", query: ListFilesQuery{}, wantLimit: defaultListLimit},
+		{name: "limit kept", query: ListFilesQuery{Limit: 10}, wantLimit: 10},
+		{name: "largest limit", query: ListFilesQuery{Limit: maxListLimit}, wantLimit: maxListLimit},
+		{name: "limit clamped", query: ListFilesQuery{Limit: maxListLimit + 1}, wantLimit: maxListLimit},
+		{name: "negative limit", query: ListFilesQuery{Limit: -1}, wantErr: ErrInvalidListQuery},
+		{name: "unknown sort field", query: ListFilesQuery{SortBy: "owner"}, wantErr: ErrInvalidListQuery},
+		{name: "size range reversed", query: ListFilesQuery{MinSize: 10, MaxSize: 5}, wantErr: ErrInvalidListQuery},
+		{name: "cursor", query: ListFilesQuery{Cursor: uploadDateCursor}, wantLimit: defaultListLimit},
+		{name: "cursor not base64", query: ListFilesQuery{Cursor: "not a cursor!"}, wantErr: ErrInvalidListQuery},
+		{name: "cursor not JSON", query: ListFilesQuery{Cursor: base64.RawURLEncoding.EncodeToString([]byte("{"))}, wantErr: ErrInvalidListQuery},
+		{name: "cursor of another sort field", query: ListFilesQuery{SortBy: SortByFilename, Cursor: uploadDateCursor}, wantErr: ErrInvalidListQuery},
+		{name: "cursor of another sort order", query: ListFilesQuery{Descending: true, Cursor: uploadDateCursor}, wantErr: ErrInvalidListQuery},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := tt.query
+			_, err := q.normalize()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("normalize() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && q.Limit != tt.wantLimit {
+				t.Errorf("normalize() set limit %d, want %d", q.Limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestListCursorPagination(t *testing.T) {
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var files []BlobInfo
+	for i := range 5 {
+		// Upload dates are shared in pairs, the ID breaks the tie.
+		files = append(files, BlobInfo{
+			ID:         "id-" + strconv.Itoa(i),
+			Filename:   "file-" + strconv.Itoa(4-i),
+			UploadDate: base.Add(time.Duration(min(i, 4-i)) * time.Hour),
+		})
+	}
+
+	ascending := []string{"id-0", "id-4", "id-1", "id-3", "id-2"}
+	for _, descending := range []bool{false, true} {
+		t.Run("descending="+strconv.FormatBool(descending), func(t *testing.T) {
+			want := slices.Clone(ascending)
+			if descending {
+				slices.Reverse(want)
+			}
+
+			var got []string
+			q := ListFilesQuery{Descending: descending, Limit: 2}
+			for range len(files) {
+				page := q
+				after, err := page.normalize()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var rest []BlobInfo
+				for _, info := range files {
+					if after == nil || page.compare(sortKey(info), *after) > 0 {
+						rest = append(rest, info)
+					}
+				}
+				slices.SortFunc(rest, func(a, b BlobInfo) int {
+					return page.compare(sortKey(a), sortKey(b))
+				})
+				result := paginate(page, rest)
+				for _, info := range result.Files {
+					got = append(got, info.ID)
+				}
+				if result.NextCursor == "" {
+					break
+				}
+				q.Cursor = result.NextCursor
+			}
+
+			if !slices.Equal(got, want) {
+				t.Errorf("pages = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -6,6 +6,8 @@ package filesrv
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 	"time"
 
 	"github.com/go-kit/log"
@@ -34,29 +36,29 @@ type loggingMiddleware struct {
 }
 
 // InitUpload logs metadata and duration for InitUpload calls.
-func (mw loggingMiddleware) InitUpload(ctx context.Context, filename string, totalChunks int, chunkSize int) (id string, err error) {
+func (mw loggingMiddleware) InitUpload(ctx context.Context, filename string, totalChunks int, chunkSize int, fileSize int64, contentType string, keepRevisions int) (id string, err error) {
 	defer func(begin time.Time) {
-		if logErr := mw.logger.Log("method", "InitUpload", "fileName", filename, "took", time.Since(begin), "err", err); logErr != nil {
+		if logErr := mw.logger.Log("method", "InitUpload", "fileName", filename, "fileSize", fileSize, "contentType", contentType, "keepRevisions", keepRevisions, "took", time.Since(begin), "err", err); logErr != nil {
 			fmt.Println("log error:", logErr)
 		}
 	}(time.Now())
-	return mw.next.InitUpload(ctx, filename, totalChunks, chunkSize)
+	return mw.next.InitUpload(ctx, filename, totalChunks, chunkSize, fileSize, contentType, keepRevisions)
 }
 
 // UploadChunk logs metadata and duration for UploadChunk calls.
-func (mw loggingMiddleware) UploadChunk(ctx context.Context, sessionID string, chunkNum int, data []byte) (err error) {
+func (mw loggingMiddleware) UploadChunk(ctx context.Context, sessionID string, chunkNum int, checksum ChunkChecksum, data io.Reader) (err error) {
 	defer func(begin time.Time) {
-		if logErr := mw.logger.Log("method", "UploadChunk", "sessionID", sessionID, "chunkNum", chunkNum, "took", time.Since(begin), "err", err); logErr != nil {
+		if logErr := mw.logger.Log("method", "UploadChunk", "sessionID", sessionID, "chunkNum", chunkNum, "checksum", checksum.String(), "took", time.Since(begin), "err", err); logErr != nil {
 			fmt.Println("log error:", logErr)
 		}
 	}(time.Now())
-	return mw.next.UploadChunk(ctx, sessionID, chunkNum, data)
+	return mw.next.UploadChunk(ctx, sessionID, chunkNum, checksum, data)
 }
 
 // FinalizeUpload logs metadata and duration for FinalizeUpload calls.
-func (mw loggingMiddleware) FinalizeUpload(ctx context.Context, sessionID string) (fileID string, err error) {
+func (mw loggingMiddleware) FinalizeUpload(ctx context.Context, sessionID string) (file BlobInfo, err error) {
 	defer func(begin time.Time) {
-		if logErr := mw.logger.Log("method", "FinalizeUpload", "sessionID", sessionID, "fileID", fileID, "took", time.Since(begin), "err", err); logErr != nil {
+		if logErr := mw.logger.Log("method", "FinalizeUpload", "sessionID", sessionID, "fileID", file.ID, "sha256", file.Metadata.SHA256, "took", time.Since(begin), "err", err); logErr != nil {
 			fmt.Println("log error:", logErr)
 		}
 	}(time.Now())
@@ -74,12 +76,235 @@ func (mw loggingMiddleware) AbortUpload(ctx context.Context, sessionID string) (
 }
 
 // DownloadFile logs metadata and duration for DownloadFile calls,
-// including the size of the downloaded data.
-func (mw loggingMiddleware) DownloadFile(ctx context.Context, filename string) (downloadedData []byte, err error) {
+// including the size of the file being streamed.
+func (mw loggingMiddleware) DownloadFile(ctx context.Context, filename string, revision int) (file BlobReader, err error) {
 	defer func(begin time.Time) {
-		if logErr := mw.logger.Log("method", "DownloadFile", "filename", filename, "downloadedData", len(downloadedData), "took", time.Since(begin), "err", err); logErr != nil {
+		var length int64
+		if file != nil {
+			length = file.Info().Length
+		}
+		if logErr := mw.logger.Log("method", "DownloadFile", "filename", filename, "revision", revision, "length", length, "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.DownloadFile(ctx, filename, revision)
+}
+
+// DownloadFileByID logs metadata and duration for DownloadFileByID calls,
+// including the size of the file being streamed.
+func (mw loggingMiddleware) DownloadFileByID(ctx context.Context, fileID string) (file BlobReader, err error) {
+	defer func(begin time.Time) {
+		var length int64
+		if file != nil {
+			length = file.Info().Length
+		}
+		if logErr := mw.logger.Log("method", "DownloadFileByID", "fileID", fileID, "length", length, "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.DownloadFileByID(ctx, fileID)
+}
+
+// GetFileInfo logs metadata and duration for GetFileInfo calls.
+func (mw loggingMiddleware) GetFileInfo(ctx context.Context, fileID string) (info BlobInfo, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "GetFileInfo", "fileID", fileID, "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.GetFileInfo(ctx, fileID)
+}
+
+// ListFiles logs the query, number of results and duration for ListFiles calls.
+func (mw loggingMiddleware) ListFiles(ctx context.Context, query ListFilesQuery) (page ListFilesPage, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "ListFiles", "prefix", query.NamePrefix, "sortBy", query.SortBy, "files", len(page.Files), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.ListFiles(ctx, query)
+}
+
+// ListRevisions logs metadata and duration for ListRevisions calls.
+func (mw loggingMiddleware) ListRevisions(ctx context.Context, filename string) (revisions []FileRevision, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "ListRevisions", "filename", filename, "revisions", len(revisions), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.ListRevisions(ctx, filename)
+}
+
+// PruneRevisions logs metadata and duration for PruneRevisions calls,
+// including the number of deleted revisions.
+func (mw loggingMiddleware) PruneRevisions(ctx context.Context, filename string, keep int) (deleted []string, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "PruneRevisions", "filename", filename, "keep", keep, "deleted", len(deleted), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.PruneRevisions(ctx, filename, keep)
+}
+
+// TrashFile logs metadata and duration for TrashFile calls.
+func (mw loggingMiddleware) TrashFile(ctx context.Context, fileID string) (info BlobInfo, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "TrashFile", "fileID", fileID, "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.TrashFile(ctx, fileID)
+}
+
+// RestoreFile logs metadata and duration for RestoreFile calls.
+func (mw loggingMiddleware) RestoreFile(ctx context.Context, fileID string) (info BlobInfo, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "RestoreFile", "fileID", fileID, "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.RestoreFile(ctx, fileID)
+}
+
+// PurgeFile logs metadata and duration for PurgeFile calls.
+func (mw loggingMiddleware) PurgeFile(ctx context.Context, fileID string) (err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "PurgeFile", "fileID", fileID, "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.PurgeFile(ctx, fileID)
+}
+
+// PurgeTrash logs the cutoff, number of purged files and duration
+// for PurgeTrash calls.
+func (mw loggingMiddleware) PurgeTrash(ctx context.Context, olderThan time.Time) (purged int, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "PurgeTrash", "olderThan", olderThan, "purged", purged, "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.PurgeTrash(ctx, olderThan)
+}
+
+// GetUploadStatus logs metadata and duration for GetUploadStatus calls.
+func (mw loggingMiddleware) GetUploadStatus(ctx context.Context, sessionID string) (status UploadStatus, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "GetUploadStatus", "sessionID", sessionID, "status", status.Status, "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.GetUploadStatus(ctx, sessionID)
+}
+
+// ExpireSessions logs the number of reclaimed sessions and duration
+// for ExpireSessions calls.
+func (mw loggingMiddleware) ExpireSessions(ctx context.Context) (expired int, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "ExpireSessions", "expired", expired, "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.ExpireSessions(ctx)
+}
+
+// GetStats logs the reported stats and duration for GetStats calls.
+func (mw loggingMiddleware) GetStats(ctx context.Context) (stats Stats, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "GetStats", "activeSessions", stats.ActiveSessions, "stagedBytes", stats.StagedBytes, "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.GetStats(ctx)
+}
+
+// ReconcileStaging logs the reconciliation summary and duration
+// for ReconcileStaging calls.
+func (mw loggingMiddleware) ReconcileStaging(ctx context.Context) (report ReconcileReport, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log(
+			"method", "ReconcileStaging",
+			"orphanedSessions", report.OrphanedSessions,
+			"repairedSessions", report.RepairedSessions,
+			"chunksAdded", report.ChunksAdded,
+			"chunksDropped", report.ChunksDropped,
+			"took", time.Since(begin),
+			"err", err,
+		); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.ReconcileStaging(ctx)
+}
+
+// GetQuota logs metadata and duration for GetQuota calls.
+func (mw loggingMiddleware) GetQuota(ctx context.Context, owner string) (usage QuotaUsage, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "GetQuota", "owner", owner, "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.GetQuota(ctx, owner)
+}
+
+// SetQuota logs the new limits and duration for SetQuota calls.
+func (mw loggingMiddleware) SetQuota(ctx context.Context, owner string, quota Quota) (usage QuotaUsage, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "SetQuota", "owner", owner, "maxBytes", quota.MaxBytes, "maxFiles", quota.MaxFiles, "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.SetQuota(ctx, owner, quota)
+}
+
+// GrantAccess logs the grant and duration for GrantAccess calls.
+func (mw loggingMiddleware) GrantAccess(ctx context.Context, fileID string, grant Grant) (info BlobInfo, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "GrantAccess", "fileID", fileID, "user", grant.Principal, "group", grant.Group, "permissions", strings.Join(grant.Permissions, ","), "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.GrantAccess(ctx, fileID, grant)
+}
+
+// RevokeAccess logs the revoked grant and duration for RevokeAccess calls.
+func (mw loggingMiddleware) RevokeAccess(ctx context.Context, fileID string, grant Grant) (info BlobInfo, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "RevokeAccess", "fileID", fileID, "user", grant.Principal, "group", grant.Group, "permissions", strings.Join(grant.Permissions, ","), "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.RevokeAccess(ctx, fileID, grant)
+}
+
+// SetPublic logs the new visibility and duration for SetPublic calls.
+func (mw loggingMiddleware) SetPublic(ctx context.Context, fileID string, public bool) (info BlobInfo, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "SetPublic", "fileID", fileID, "public", public, "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.SetPublic(ctx, fileID, public)
+}
+
+// SignDownloadURL logs the restrictions and expiry of issued pre-signed
+// download URLs, never the URL itself.
+func (mw loggingMiddleware) SignDownloadURL(ctx context.Context, fileID string, opts DownloadURLOptions) (signed SignedURL, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "SignDownloadURL", "fileID", fileID, "clientIP", opts.ClientIP, "expiresAt", signed.ExpiresAt, "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
+			fmt.Println("log error:", logErr)
+		}
+	}(time.Now())
+	return mw.next.SignDownloadURL(ctx, fileID, opts)
+}
+
+// SignUploadGrant logs the limits and expiry of issued upload grants,
+// never the token itself.
+func (mw loggingMiddleware) SignUploadGrant(ctx context.Context, sessionID string, opts UploadGrantOptions) (grant SignedUploadGrant, err error) {
+	defer func(begin time.Time) {
+		if logErr := mw.logger.Log("method", "SignUploadGrant", "sessionID", sessionID, "maxFileSize", opts.MaxFileSize, "contentType", opts.ContentType, "expiresAt", grant.ExpiresAt, "principal", PrincipalFromContext(ctx), "took", time.Since(begin), "err", err); logErr != nil {
 			fmt.Println("log error:", logErr)
 		}
 	}(time.Now())
-	return mw.next.DownloadFile(ctx, filename)
+	return mw.next.SignUploadGrant(ctx, sessionID, opts)
 }
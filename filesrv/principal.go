@@ -0,0 +1,46 @@
+// Package filesrv tracks the principal acting on the FileService through
+// the request context.
+package filesrv
+
+import "context"
+
+// anonymousPrincipal is recorded when no principal is present in the context.
+const anonymousPrincipal = "anonymous"
+
+// principalContextKey is the context key holding the acting Identity.
+type principalContextKey struct{}
+
+// Identity is an authenticated caller of the service.
+type Identity struct {
+	Principal string   // Name of the caller, recorded as owner of its uploads
+	Groups    []string // Groups the caller belongs to, used by file ACLs
+	Tenant    string   // Tenant the credentials are bound to, empty if not bound
+}
+
+// ContextWithIdentity returns a copy of ctx carrying the identity on whose
+// behalf the service is called.
+func ContextWithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored in ctx. Its principal is
+// "anonymous" if none was set.
+func IdentityFromContext(ctx context.Context) Identity {
+	id, _ := ctx.Value(principalContextKey{}).(Identity)
+	if id.Principal == "" {
+		id.Principal = anonymousPrincipal
+	}
+	return id
+}
+
+// ContextWithPrincipal returns a copy of ctx carrying the principal on whose
+// behalf the service is called, without any groups.
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return ContextWithIdentity(ctx, Identity{Principal: principal})
+}
+
+// PrincipalFromContext returns the principal stored in ctx, or "anonymous"
+// if none was set.
+func PrincipalFromContext(ctx context.Context) string {
+	return IdentityFromContext(ctx).Principal
+}
@@ -0,0 +1,182 @@
+// Package filesrv implements per-owner storage quotas limiting the bytes
+// and number of files each owner may keep in the blob store.
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Quota limits the storage of an owner. Zero values disable the
+// corresponding limit.
+type Quota struct {
+	MaxBytes int64 `bson:"max_bytes" json:"max_bytes"` // Total size of stored files in bytes
+	MaxFiles int64 `bson:"max_files" json:"max_files"` // Number of stored files
+}
+
+// QuotaUsage is the quota of an owner along with its current usage.
+// Files in trash count towards the usage until they are purged.
+type QuotaUsage struct {
+	Owner     string    `bson:"_id" json:"owner"`             // Principal owning the files
+	MaxBytes  int64     `bson:"max_bytes" json:"max_bytes"`   // Limit on the total size of stored files, 0 for none
+	MaxFiles  int64     `bson:"max_files" json:"max_files"`   // Limit on the number of stored files, 0 for none
+	UsedBytes int64     `bson:"used_bytes" json:"used_bytes"` // Total size of stored files in bytes
+	UsedFiles int64     `bson:"used_files" json:"used_files"` // Number of stored files
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"` // Time of the last change
+}
+
+// exceeded returns an error wrapping ErrQuotaExceeded if storing
+// another file of size bytes would exceed the quota.
+func (u QuotaUsage) exceeded(size int64) error {
+	switch {
+	case u.MaxBytes > 0 && u.UsedBytes+size > u.MaxBytes:
+		return withDetails(ErrQuotaExceeded, map[string]any{
+			"limit": "bytes", "max": u.MaxBytes, "used": u.UsedBytes, "requested": size,
+		})
+	case u.MaxFiles > 0 && u.UsedFiles+1 > u.MaxFiles:
+		return withDetails(ErrQuotaExceeded, map[string]any{
+			"limit": "files", "max": u.MaxFiles, "used": u.UsedFiles,
+		})
+	}
+	return nil
+}
+
+// GetQuota returns the quota and usage of the owner. Callers
+// may only read their own quota unless they are administrators.
+func (s *fileService) GetQuota(ctx context.Context, owner string) (QuotaUsage, error) {
+	id := IdentityFromContext(ctx)
+	if owner != id.Principal && !s.isAdmin(id) {
+		return QuotaUsage{}, withDetails(ErrForbidden, "only administrators may read the quota of others")
+	}
+	return s.quotaUsage(ctx, owner)
+}
+
+// quotaUsage loads the quota and usage of the owner. Owners
+// without a stored quota get the default quota.
+func (s *fileService) quotaUsage(ctx context.Context, owner string) (QuotaUsage, error) {
+	usage := QuotaUsage{}
+	err := s.quotas.FindOne(ctx, bson.M{"_id": owner}).Decode(&usage)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return QuotaUsage{
+			Owner:    owner,
+			MaxBytes: s.defaultQuota.MaxBytes,
+			MaxFiles: s.defaultQuota.MaxFiles,
+		}, nil
+	}
+	return usage, err
+}
+
+// SetQuota replaces the limits of the owner, keeping its usage.
+// Lowering a limit below the usage only blocks new uploads. Only
+// administrators may change quotas, including their own.
+func (s *fileService) SetQuota(ctx context.Context, owner string, quota Quota) (QuotaUsage, error) {
+	if !s.isAdmin(IdentityFromContext(ctx)) {
+		return QuotaUsage{}, withDetails(ErrForbidden, "only administrators may change quotas")
+	}
+	if owner == "" || quota.MaxBytes < 0 || quota.MaxFiles < 0 {
+		return QuotaUsage{}, ErrInvalidRequest
+	}
+
+	usage := QuotaUsage{}
+	err := s.quotas.FindOneAndUpdate(ctx,
+		bson.M{"_id": owner},
+		bson.M{
+			"$set":         bson.M{"max_bytes": quota.MaxBytes, "max_files": quota.MaxFiles, "updated_at": time.Now()},
+			"$setOnInsert": bson.M{"used_bytes": 0, "used_files": 0},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&usage)
+	return usage, err
+}
+
+// reserveQuota atomically adds a file of size bytes to the usage
+// of the owner, failing with ErrQuotaExceeded if it does not fit.
+func (s *fileService) reserveQuota(ctx context.Context, owner string, size int64) error {
+	_, err := s.quotas.UpdateOne(ctx,
+		bson.M{"_id": owner},
+		bson.M{"$setOnInsert": bson.M{
+			"max_bytes":  s.defaultQuota.MaxBytes,
+			"max_files":  s.defaultQuota.MaxFiles,
+			"used_bytes": 0,
+			"used_files": 0,
+			"updated_at": time.Now(),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	res, err := s.quotas.UpdateOne(ctx,
+		bson.M{"_id": owner, "$expr": bson.M{"$and": bson.A{
+			withinLimit("$max_bytes", bson.M{"$add": bson.A{"$used_bytes", size}}),
+			withinLimit("$max_files", bson.M{"$add": bson.A{"$used_files", 1}}),
+		}}},
+		bson.M{
+			"$inc": bson.M{"used_bytes": size, "used_files": 1},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		usage, err := s.quotaUsage(ctx, owner)
+		if err != nil {
+			return err
+		}
+		if err := usage.exceeded(size); err != nil {
+			return err
+		}
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// releaseQuota removes a file of size bytes from the usage of the
+// owner.
+func (s *fileService) releaseQuota(ctx context.Context, owner string, size int64) error {
+	if owner == "" {
+		// Files stored before quotas were introduced have no owner.
+		return nil
+	}
+	_, err := s.quotas.UpdateOne(ctx,
+		bson.M{"_id": owner},
+		bson.M{
+			"$inc": bson.M{"used_bytes": -size, "used_files": -1},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+	)
+	return err
+}
+
+// deleteFile permanently deletes a stored file and releases its
+// quota. A file deleted concurrently is not released twice.
+func (s *fileService) deleteFile(ctx context.Context, info BlobInfo) error {
+	if err := s.blobs.Delete(ctx, info.ID); err != nil {
+		return err
+	}
+	return s.releaseQuota(ctx, info.Metadata.Owner, info.Length)
+}
+
+// isAdmin reports whether the identity belongs to the group
+// of administrators. Nobody is an administrator if no group is
+// configured.
+func (s *fileService) isAdmin(id Identity) bool {
+	return s.adminGroup != "" && slices.Contains(id.Groups, s.adminGroup)
+}
+
+// withinLimit builds an aggregation expression that is true if the
+// limit field is disabled or value does not exceed it.
+func withinLimit(limit string, value any) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"$lte": bson.A{limit, 0}},
+		bson.M{"$lte": bson.A{value, limit}},
+	}}
+}
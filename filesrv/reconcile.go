@@ -0,0 +1,164 @@
+// Package filesrv implements the reconciliation of staged chunks with the
+// upload metadata, repairing the state left behind by a crash.
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ReconcileReport summarizes the changes made by ReconcileStaging.
+type ReconcileReport struct {
+	OrphanedSessions int `json:"orphaned_sessions"` // Staged sessions removed because they are not in progress
+	RepairedSessions int `json:"repaired_sessions"` // In-progress sessions whose uploaded chunks were corrected
+	ChunksAdded      int `json:"chunks_added"`      // Staged chunks missing from the metadata
+	ChunksDropped    int `json:"chunks_dropped"`    // Chunks in the metadata that were not staged
+}
+
+// ReconcileStaging compares the staged chunks with the upload
+// metadata. Staged data of sessions that are unknown or no
+// longer in progress is removed, and the uploaded chunks of
+// in-progress sessions are corrected to what is staged.
+// Sessions being finalized are left alone, another replica may
+// still be reading their chunks. It must run before the service
+// accepts uploads.
+func (s *fileService) ReconcileStaging(ctx context.Context) (ReconcileReport, error) {
+	var report ReconcileReport
+
+	staged, err := s.stager.Sessions(ctx)
+	if err != nil {
+		return report, err
+	}
+	orphans := make(map[string]bool, len(staged))
+	for _, sessionID := range staged {
+		orphans[sessionID] = true
+	}
+
+	cursor, err := s.metadata.Find(ctx, bson.M{
+		"status": bson.M{"$in": bson.A{SessionInProgress, SessionFinalizing}},
+	})
+	if err != nil {
+		return report, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		meta := UploadMetadata{}
+		if err := cursor.Decode(&meta); err != nil {
+			return report, err
+		}
+		delete(orphans, meta.ID)
+		if meta.Status != SessionInProgress {
+			continue
+		}
+
+		added, dropped, err := s.repairUploadedChunks(ctx, meta)
+		if err != nil {
+			return report, err
+		}
+		if added+dropped > 0 {
+			report.RepairedSessions++
+			report.ChunksAdded += added
+			report.ChunksDropped += dropped
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return report, err
+	}
+
+	for sessionID := range orphans {
+		if err := s.removedProcessedChunks(ctx, sessionID); err != nil {
+			return report, err
+		}
+		report.OrphanedSessions++
+	}
+	return report, nil
+}
+
+// errConcurrentChunkUpdate is returned by repairUploadedChunks when the
+// uploaded chunks of a session kept changing underneath it.
+var errConcurrentChunkUpdate = errors.New("uploaded chunks modified concurrently")
+
+// repairUploadedChunks sets the uploaded chunks of the session
+// to the staged chunks, keeping the recorded chunk sizes and
+// digests in line. The update only applies while the uploaded
+// chunks are still those read, a chunk accepted meanwhile makes
+// it start over. It returns how many chunks were added to and
+// dropped from the metadata.
+func (s *fileService) repairUploadedChunks(ctx context.Context, meta UploadMetadata) (int, int, error) {
+	for range maxMetadataUpdateAttempts {
+		chunks, err := s.stager.Chunks(ctx, meta.ID)
+		if err != nil {
+			return 0, 0, err
+		}
+		added, dropped, update := uploadedChunksRepair(meta, chunks)
+		if update == nil {
+			return 0, 0, nil
+		}
+
+		res, err := s.metadata.UpdateOne(ctx, bson.M{
+			"_id":             meta.ID,
+			"status":          SessionInProgress,
+			"uploaded_chunks": meta.UploadedChunks,
+		}, update)
+		if err != nil {
+			return 0, 0, err
+		}
+		if res.MatchedCount == 1 {
+			return added, dropped, nil
+		}
+
+		meta, err = s.findSession(ctx, meta.ID)
+		if err != nil {
+			return 0, 0, err
+		}
+		if meta.Status != SessionInProgress {
+			return 0, 0, nil
+		}
+	}
+	return 0, 0, errConcurrentChunkUpdate
+}
+
+// uploadedChunksRepair builds the update aligning the metadata
+// of the session with the staged chunks, or nil if they agree,
+// along with the number of chunks added and dropped.
+func uploadedChunksRepair(meta UploadMetadata, chunks map[int]int64) (int, int, bson.M) {
+	set, unset := bson.M{}, bson.M{}
+	added, dropped := 0, 0
+
+	for chunkNum, size := range chunks {
+		key := strconv.Itoa(chunkNum)
+		if !slices.Contains(meta.UploadedChunks, chunkNum) {
+			added++
+		}
+		if meta.ChunkLengths[key] != size {
+			set["chunk_lengths."+key] = size
+		}
+	}
+	for _, chunkNum := range meta.UploadedChunks {
+		if _, ok := chunks[chunkNum]; !ok {
+			key := strconv.Itoa(chunkNum)
+			unset["chunk_lengths."+key] = ""
+			unset["chunk_digests."+key] = ""
+			dropped++
+		}
+	}
+	if added+dropped == 0 && len(set) == 0 {
+		return 0, 0, nil
+	}
+
+	// An empty array rather than null, so chunks can still be added.
+	uploaded := slices.AppendSeq(make([]int, 0, len(chunks)), maps.Keys(chunks))
+	slices.Sort(uploaded)
+	set["uploaded_chunks"] = uploaded
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return added, dropped, update
+}
@@ -0,0 +1,80 @@
+package filesrv
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUploadedChunksRepair(t *testing.T) {
+	meta := UploadMetadata{
+		ID:             "session-1",
+		UploadedChunks: []int{0, 1, 2},
+		ChunkLengths:   map[string]int64{"0": 10, "1": 10, "2": 4},
+		ChunkDigests:   map[string]string{"0": "sha256:00", "1": "sha256:01", "2": "sha256:02"},
+	}
+
+	tests := []struct {
+		name        string
+		chunks      map[int]int64
+		wantAdded   int
+		wantDropped int
+		wantUpdate  bson.M
+	}{
+		{
+			name:   "in line",
+			chunks: map[int]int64{0: 10, 1: 10, 2: 4},
+		},
+		{
+			name:      "chunk staged before the crash",
+			chunks:    map[int]int64{0: 10, 1: 10, 2: 4, 3: 7},
+			wantAdded: 1,
+			wantUpdate: bson.M{"$set": bson.M{
+				"chunk_lengths.3": int64(7),
+				"uploaded_chunks": []int{0, 1, 2, 3},
+			}},
+		},
+		{
+			name:        "chunk lost",
+			chunks:      map[int]int64{0: 10, 2: 4},
+			wantDropped: 1,
+			wantUpdate: bson.M{
+				"$set":   bson.M{"uploaded_chunks": []int{0, 2}},
+				"$unset": bson.M{"chunk_lengths.1": "", "chunk_digests.1": ""},
+			},
+		},
+		{
+			name:   "chunk replaced with another size",
+			chunks: map[int]int64{0: 10, 1: 10, 2: 6},
+			wantUpdate: bson.M{"$set": bson.M{
+				"chunk_lengths.2": int64(6),
+				"uploaded_chunks": []int{0, 1, 2},
+			}},
+		},
+		{
+			name:        "nothing staged",
+			chunks:      map[int]int64{},
+			wantDropped: 3,
+			wantUpdate: bson.M{
+				"$set": bson.M{"uploaded_chunks": []int{}},
+				"$unset": bson.M{
+					"chunk_lengths.0": "", "chunk_digests.0": "",
+					"chunk_lengths.1": "", "chunk_digests.1": "",
+					"chunk_lengths.2": "", "chunk_digests.2": "",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, dropped, update := uploadedChunksRepair(meta, tt.chunks)
+			if added != tt.wantAdded || dropped != tt.wantDropped {
+				t.Errorf("uploadedChunksRepair() added %d and dropped %d, want %d and %d", added, dropped, tt.wantAdded, tt.wantDropped)
+			}
+			if !reflect.DeepEqual(update, tt.wantUpdate) {
+				t.Errorf("uploadedChunksRepair() update = %v, want %v", update, tt.wantUpdate)
+			}
+		})
+	}
+}
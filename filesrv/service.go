@@ -1,56 +1,159 @@
 // Package filesrv provides a file upload and download service
-// built on top of MongoDB GridFS. It supports chunked uploads
+// built on top of a pluggable BlobStore such as MongoDB GridFS. It supports chunked uploads
 // with resumability, metadata tracking, and safe disk buffering.
 package filesrv
 
 import (
 	"context"
+	"crypto/md5" // #nosec G501 -- MD5 is exposed for compatibility, not security
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"fmt"
 	"io"
-	"os"
+	"maps"
+	"mime"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// defaultSessionTTL is the idle time after which an upload
+// session expires when Options.SessionTTL is not set.
+const defaultSessionTTL = 24 * time.Hour
+
+// defaultFinalizeTimeout is the time after which a session still
+// finalizing is considered interrupted when Options.FinalizeTimeout
+// is not set.
+const defaultFinalizeTimeout = time.Hour
+
+// defaultSignedURLTTL is the lifetime of pre-signed URLs when
+// neither the request nor Options.SignedURLTTL sets one.
+const defaultSignedURLTTL = 15 * time.Minute
+
+// Options tunes the behaviour of the FileService. Zero values
+// select the defaults.
+type Options struct {
+	SessionTTL       time.Duration // Idle time after which an in-progress upload session expires
+	FinalizeTimeout  time.Duration // Time after which a session still finalizing is failed and its resources released
+	MaxFileSize      int64         // Largest file size accepted at init in bytes, 0 for no limit
+	DefaultQuota     Quota         // Quota of owners without an explicitly set quota
+	AdminGroup       string        // Group whose members may read and change the quotas of every owner
+	Signer           *URLSigner    // Signs pre-signed URLs, nil disables them
+	SignedURLBase    string        // External URL of the service prefixed to pre-signed URLs
+	SignedURLTTL     time.Duration // Default lifetime of pre-signed URLs
+	MaxSignedURLTTL  time.Duration // Longest lifetime a client may request, 0 for no limit
+	OpenUnownedFiles bool          // Let every caller access files stored before owners were recorded
+}
+
 // fileService implements the FileService interface and handles
 // file uploads, chunk buffering, metadata storage, and downloads.
 type fileService struct {
-	metadata *mongo.Collection // MongoDB collection to track upload metadata
-	fsBucket *gridfs.Bucket    // GridFS bucket for final file storage
-	tempDir  string            // Directory to temporarily buffer chunk files
+	metadata        *mongo.Collection // MongoDB collection to track upload metadata
+	quotas          *mongo.Collection // MongoDB collection holding the quota and usage per owner
+	blobs           BlobStore         // Final storage for assembled files
+	stager          ChunkStager       // Buffer for chunks of in-progress uploads
+	ttl             time.Duration     // Idle time after which an upload session expires
+	finalizeTimeout time.Duration     // Time after which a finalizing session is considered interrupted
+	maxSize         int64             // Largest accepted file size in bytes, 0 for no limit
+	defaultQuota    Quota             // Quota of owners without an explicitly set quota
+	adminGroup      string            // Group of administrators, empty for none
+	signer          *URLSigner        // Signer of pre-signed URLs, nil when disabled
+	signedURLBase   string            // Prefix of pre-signed URLs
+	signedURLTTL    time.Duration     // Default lifetime of pre-signed URLs
+	maxSignedURLTTL time.Duration     // Longest lifetime of pre-signed URLs, 0 for no limit
+	openUnowned     bool              // Whether files without owner are open to every caller
 }
 
 // NewFileService creates a new instance of fileService.
-func NewFileService(metaColl *mongo.Collection, fsBucket *gridfs.Bucket) FileService {
+func NewFileService(metaColl, quotaColl *mongo.Collection, blobs BlobStore, stager ChunkStager, opts Options) FileService {
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = defaultSessionTTL
+	}
+	if opts.FinalizeTimeout <= 0 {
+		opts.FinalizeTimeout = defaultFinalizeTimeout
+	}
+	if opts.SignedURLTTL <= 0 {
+		opts.SignedURLTTL = defaultSignedURLTTL
+	}
 	return &fileService{
-		metadata: metaColl,
-		fsBucket: fsBucket,
-		tempDir:  "./tmp_uploads",
+		metadata:        metaColl,
+		quotas:          quotaColl,
+		blobs:           blobs,
+		stager:          stager,
+		ttl:             opts.SessionTTL,
+		finalizeTimeout: opts.FinalizeTimeout,
+		maxSize:         opts.MaxFileSize,
+		defaultQuota:    opts.DefaultQuota,
+		adminGroup:      opts.AdminGroup,
+		signer:          opts.Signer,
+		signedURLBase:   strings.TrimSuffix(opts.SignedURLBase, "/"),
+		signedURLTTL:    opts.SignedURLTTL,
+		maxSignedURLTTL: opts.MaxSignedURLTTL,
+		openUnowned:     opts.OpenUnownedFiles,
 	}
 }
 
 // InitUpload initializes a new upload session by storing
-// metadata such as filename, chunk size, and total chunks.
-// It returns a session ID to be used for uploading chunks.
-func (s *fileService) InitUpload(ctx context.Context, filename string, totalChunks, chunkSize int) (string, error) {
+// metadata such as filename, content type, chunk size, total
+// chunks and file size. The declared file size must match the
+// chunk layout and stay within the maximum file size and the
+// quota of the caller, who becomes the owner of the file. A
+// retention count given here is stored with the file and
+// applied when it is finalized. It returns a session ID to be
+// used for uploading chunks.
+func (s *fileService) InitUpload(ctx context.Context, filename string, totalChunks, chunkSize int, fileSize int64, contentType string, keepRevisions int) (string, error) {
+	if err := validateFileSize(totalChunks, chunkSize, fileSize); err != nil {
+		return "", err
+	}
+	if keepRevisions < 0 {
+		return "", ErrInvalidRetention
+	}
+	if s.maxSize > 0 && fileSize > s.maxSize {
+		return "", withDetails(ErrFileTooLarge, map[string]any{"max_file_size": s.maxSize})
+	}
+
+	owner := PrincipalFromContext(ctx)
+	usage, err := s.quotaUsage(ctx, owner)
+	if err != nil {
+		return "", err
+	}
+	if err := usage.exceeded(fileSize); err != nil {
+		return "", err
+	}
+
 	sessionID := primitive.NewObjectID().Hex()
 
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	now := time.Now()
 	meta := UploadMetadata{
 		ID:             sessionID,
 		Filename:       filename,
+		Owner:          owner,
+		ContentType:    contentType,
 		TotalChunks:    totalChunks,
 		UploadedChunks: []int{},
 		ChunkSize:      chunkSize,
-		Status:         "in_progress",
-		CreatedAt:      time.Now(),
+		FileSize:       fileSize,
+		KeepRevisions:  keepRevisions,
+		Status:         SessionInProgress,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ExpiresAt:      now.Add(s.ttl),
 	}
-	_, err := s.metadata.InsertOne(ctx, meta)
+	_, err = s.metadata.InsertOne(ctx, meta)
 	if err != nil {
 		return "", err
 	}
@@ -58,125 +161,531 @@ func (s *fileService) InitUpload(ctx context.Context, filename string, totalChun
 	return sessionID, nil
 }
 
-// UploadChunk saves an individual chunk of a file to local
-// disk and updates the metadata to mark the chunk as received.
-func (s *fileService) UploadChunk(ctx context.Context, sessionID string, chunkNum int, data []byte) error {
-	meta := UploadMetadata{}
-	err := s.metadata.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&meta)
+// UploadChunk streams an individual chunk of a file into the
+// stager while hashing it, rejects the chunk if it does not match
+// the client supplied checksum, and updates the metadata to mark
+// the chunk as received along with its digest. Chunks must lie
+// within the session and match its chunk size, and are only
+// accepted while the session is in progress, from its owner or
+// a client holding an upload grant for it. Every accepted
+// chunk extends the expiry of the session by the session TTL.
+func (s *fileService) UploadChunk(ctx context.Context, sessionID string, chunkNum int, checksum ChunkChecksum, data io.Reader) error {
+	meta, err := s.findSession(ctx, sessionID)
 	if err != nil {
 		return err
 	}
+	if err := authorizeSession(ctx, meta); err != nil {
+		return err
+	}
+	if err := validateChunk(meta, chunkNum); err != nil {
+		return err
+	}
 
-	dir := filepath.Join(s.tempDir, sessionID)
-	if err := os.MkdirAll(dir, 0750); err != nil {
+	// A resumed upload re-sending a chunk that is already staged with the
+	// same digest does not need to be written again.
+	if !checksum.IsZero() &&
+		meta.ChunkDigests[strconv.Itoa(chunkNum)] == checksum.String() &&
+		slices.Contains(meta.UploadedChunks, chunkNum) {
+		_, err = s.metadata.UpdateOne(ctx,
+			bson.M{"_id": sessionID},
+			bson.M{"$set": bson.M{"expires_at": time.Now().Add(s.ttl)}},
+		)
 		return err
 	}
 
-	chunkPath := filepath.Join(dir, fmt.Sprintf("%d.chunk", chunkNum))
-	if err := os.WriteFile(chunkPath, data, 0600); err != nil {
+	verified, err := newChecksumReader(newChunkSizeReader(data, meta, chunkNum), checksum)
+	if err != nil {
+		return err
+	}
+	n, err := s.stager.WriteChunk(ctx, sessionID, chunkNum, verified)
+	if err != nil {
 		return err
 	}
 
-	_, err = s.metadata.UpdateOne(ctx,
-		bson.M{"_id": sessionID},
-		bson.M{"$addToSet": bson.M{"uploaded_chunks": chunkNum}},
+	now := time.Now()
+	res, err := s.metadata.UpdateOne(ctx,
+		bson.M{"_id": sessionID, "status": SessionInProgress},
+		bson.M{
+			"$addToSet": bson.M{"uploaded_chunks": chunkNum},
+			"$set": bson.M{
+				"chunk_digests." + strconv.Itoa(chunkNum): verified.Sum().String(),
+				"chunk_lengths." + strconv.Itoa(chunkNum): n,
+				"updated_at": now,
+				"expires_at": now.Add(s.ttl),
+			},
+		},
 	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		// The session ended while the chunk was being staged. Its
+		// staged chunks may already have been removed, so delete
+		// this one rather than leave it behind.
+		return errors.Join(ErrSessionNotInProgress, s.stager.DeleteChunk(ctx, sessionID, chunkNum))
+	}
+	return nil
+}
+
+// FinalizeUpload claims the session by moving it from
+// "in_progress" to "finalizing", assembles all uploaded chunks
+// in order and streams them to the blob store while computing
+// the SHA-256 and MD5 of the whole file in the same pass. The
+// session then becomes "completed", or "failed" if the file
+// could not be stored, and its staged chunks are removed. The
+// file is counted against the quota of the session owner, and
+// revisions beyond its retention count are deleted.
+// Sessions left finalizing by a crash are settled by
+// ExpireSessions once the finalize timeout passed.
+// Finalizing a completed session again returns the same file.
+// The caller must own the session or hold an upload grant for it.
+// Returns the description of the stored file.
+func (s *fileService) FinalizeUpload(ctx context.Context, sessionID string) (BlobInfo, error) {
+	meta, err := s.findSession(ctx, sessionID)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if err := authorizeSession(ctx, meta); err != nil {
+		return BlobInfo{}, err
+	}
+	if meta.Status == SessionCompleted {
+		return s.blobs.StatByID(ctx, meta.FinalFileID)
+	}
+
+	received := slices.Sorted(slices.Values(meta.UploadedChunks))
+	if missing := missingChunkRanges(received, meta.TotalChunks); len(missing) > 0 {
+		return BlobInfo{}, withDetails(ErrIncompleteUpload, map[string]any{"missing_chunks": missing})
+	}
+	if received := meta.bytesReceived(); received != meta.FileSize {
+		return BlobInfo{}, withDetails(ErrIncompleteUpload, map[string]any{
+			"bytes_received": received,
+			"file_size":      meta.FileSize,
+		})
+	}
+
+	// The quota is reserved up front and released again if the file
+	// is not stored, so concurrent uploads cannot overrun it together.
+	owner, size := meta.Owner, meta.FileSize
+	if err := s.reserveQuota(ctx, owner, size); err != nil {
+		return BlobInfo{}, err
+	}
+
+	// The outcome is recorded even if the client goes away meanwhile.
+	stateCtx := context.WithoutCancel(ctx)
+
+	// Only the caller moving the session to finalizing stores the file,
+	// concurrent finalize and abort calls fail or see the outcome.
+	meta, err = s.transitionSession(ctx, sessionID, SessionInProgress, SessionFinalizing, bson.M{
+		"finalizing_since": time.Now(),
+	})
+	if err != nil {
+		err = errors.Join(err, s.releaseQuota(stateCtx, owner, size))
+		if meta.Status == SessionCompleted {
+			return s.blobs.StatByID(ctx, meta.FinalFileID)
+		}
+		return BlobInfo{}, err
+	}
 
-	return err
+	info, err := s.storeFile(ctx, meta)
+	if err != nil {
+		_, terr := s.transitionSession(stateCtx, sessionID, SessionFinalizing, SessionFailed, bson.M{
+			"error": err.Error(),
+		})
+		go s.removedProcessedChunks(context.Background(), sessionID)
+		if terr != nil {
+			// The session is settled by whoever moves it out of
+			// finalizing, that includes releasing the quota.
+			return BlobInfo{}, errors.Join(err, terr)
+		}
+		return BlobInfo{}, errors.Join(err, s.releaseQuota(stateCtx, owner, size))
+	}
+
+	_, err = s.transitionSession(stateCtx, sessionID, SessionFinalizing, SessionCompleted, bson.M{
+		"final_file_id": info.ID,
+		"sha256":        info.Metadata.SHA256,
+		"md5":           info.Metadata.MD5,
+	})
+
+	go s.removedProcessedChunks(context.Background(), sessionID)
+
+	if err != nil {
+		return info, err
+	}
+	return info, s.applyRetention(stateCtx, info)
 }
 
-// FinalizeUpload assembles all uploaded chunks in order,
-// streams them to GridFS, marks the upload as complete,
-// and removes local chunk files from disk.
-// Returns the final file's ObjectID as a hex string.
-func (s *fileService) FinalizeUpload(ctx context.Context, sessionID string) (string, error) {
-	meta := UploadMetadata{}
-	err := s.metadata.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&meta)
+// applyRetention deletes the revisions of the file older than
+// its retention count allows, counting revisions in trash like
+// PruneRevisions. Only revisions the owner of the new revision
+// may delete are removed, others are left alone.
+func (s *fileService) applyRetention(ctx context.Context, info BlobInfo) error {
+	keep := info.Metadata.KeepRevisions
+	if keep < 1 {
+		return nil
+	}
+	revisions, err := s.blobs.Revisions(ctx, info.Filename)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	if len(meta.UploadedChunks) != meta.TotalChunks {
-		return "", errors.New("not all chunks uploaded")
+	owner := Identity{Principal: info.Metadata.Owner}
+	for _, revision := range revisions[:max(len(revisions)-keep, 0)] {
+		if !s.allows(revision.Metadata, owner, PermissionDelete) {
+			continue
+		}
+		if err := s.deleteFile(ctx, revision); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return err
+		}
 	}
+	return nil
+}
 
-	uploadStream, err := s.fsBucket.OpenUploadStream(meta.Filename)
+// storeFile streams the staged chunks of the session in order
+// into a new file of the blob store, hashing the content on the
+// way. The file is owned by the owner of the session from the
+// start, the digests are added once all content is written. A
+// partially written file is aborted on failure. Without a
+// retention count of its own the file inherits the one of the
+// latest revision.
+func (s *fileService) storeFile(ctx context.Context, meta UploadMetadata) (BlobInfo, error) {
+	blobMeta := BlobMetadata{
+		ContentType:   meta.ContentType,
+		Owner:         meta.Owner,
+		KeepRevisions: meta.KeepRevisions,
+	}
+	if blobMeta.KeepRevisions == 0 {
+		latest, err := s.blobs.Stat(ctx, meta.Filename)
+		switch {
+		case err == nil:
+			blobMeta.KeepRevisions = latest.Metadata.KeepRevisions
+		case !errors.Is(err, ErrBlobNotFound):
+			return BlobInfo{}, err
+		}
+	}
+	writer, err := s.blobs.OpenWriter(ctx, meta.Filename, blobMeta)
 	if err != nil {
-		return "", err
+		return BlobInfo{}, err
+	}
+
+	// Recording the blob lets an interrupted finalize be settled later.
+	_, err = s.metadata.UpdateOne(ctx,
+		bson.M{"_id": meta.ID, "status": SessionFinalizing},
+		bson.M{"$set": bson.M{"pending_file_id": writer.ID()}},
+	)
+	if err != nil {
+		return BlobInfo{}, errors.Join(err, writer.Abort())
 	}
-	defer uploadStream.Close()
 
+	sha256Hash, md5Hash := sha256.New(), md5.New() // #nosec G401 -- MD5 is exposed for compatibility, not security
+	dst := io.MultiWriter(writer, sha256Hash, md5Hash)
+
+	var length int64
 	for i := range meta.TotalChunks {
-		f, err := safeOpenChunk(s.tempDir, sessionID, i)
+		f, err := s.stager.OpenChunk(ctx, meta.ID, i)
 		if err != nil {
-			return "", err
+			return BlobInfo{}, errors.Join(err, writer.Abort())
 		}
-		_, err = io.Copy(uploadStream, f)
+		n, err := io.Copy(dst, f)
+		length += n
 		if cerr := f.Close(); cerr != nil {
-			return "", cerr
+			return BlobInfo{}, errors.Join(cerr, writer.Abort())
 		}
 		if err != nil {
-			return "", err
+			return BlobInfo{}, errors.Join(err, writer.Abort())
 		}
 	}
 
-	_, err = s.metadata.UpdateOne(ctx,
-		bson.M{"_id": sessionID},
-		bson.M{
-			"$set": bson.M{
-				"status":        "completed",
-				"final_file_id": uploadStream.FileID,
-			},
-		},
-	)
-
-	go s.removedProcessedChunks(sessionID)
-
-	return uploadStream.FileID.(primitive.ObjectID).Hex(), err
+	blobMeta.SHA256 = hex.EncodeToString(sha256Hash.Sum(nil))
+	blobMeta.MD5 = hex.EncodeToString(md5Hash.Sum(nil))
+	info := BlobInfo{
+		ID:       writer.ID(),
+		Filename: meta.Filename,
+		Length:   length,
+		Metadata: blobMeta,
+	}
+	writer.SetMetadata(info.Metadata)
+	if err := writer.Close(); err != nil {
+		return BlobInfo{}, err
+	}
+	info.UploadDate = time.Now().UTC()
+	return info, nil
 }
 
-// AbortUpload cancels an in-progress upload and cleans up
-// any locally stored chunk files. The metadata status is
-// marked as "aborted".
+// AbortUpload cancels an in-progress upload by moving it to
+// "aborted" and cleans up any staged chunks. Aborting an
+// aborted session again succeeds, sessions that are being or
+// were finalized cannot be aborted. Only the owner of the
+// session may abort it.
 func (s *fileService) AbortUpload(ctx context.Context, sessionID string) error {
-	if err := s.removedProcessedChunks(sessionID); err != nil {
+	meta, err := s.findSession(ctx, sessionID)
+	if err != nil {
 		return err
 	}
-	_, err := s.metadata.UpdateOne(ctx,
-		bson.M{"_id": sessionID},
-		bson.M{"$set": bson.M{"status": "aborted"}},
-	)
-	return err
+	if err := authorizeSession(ctx, meta); err != nil {
+		return err
+	}
+
+	meta, err = s.transitionSession(ctx, sessionID, SessionInProgress, SessionAborted, nil)
+	if err != nil && meta.Status != SessionAborted {
+		return err
+	}
+	return s.removedProcessedChunks(ctx, sessionID)
+}
+
+// GetUploadStatus reports which chunks of an upload session
+// have been received and which are still missing, so that an
+// interrupted upload can be resumed.
+func (s *fileService) GetUploadStatus(ctx context.Context, sessionID string) (UploadStatus, error) {
+	meta, err := s.findSession(ctx, sessionID)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	if err := authorizeSession(ctx, meta); err != nil {
+		return UploadStatus{}, err
+	}
+
+	received := slices.Clone(meta.UploadedChunks)
+	slices.Sort(received)
+	received = slices.Compact(received)
+	if received == nil {
+		received = []int{}
+	}
+
+	updatedAt := meta.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = meta.CreatedAt
+	}
+
+	return UploadStatus{
+		SessionID:      meta.ID,
+		Filename:       meta.Filename,
+		Status:         meta.Status,
+		TotalChunks:    meta.TotalChunks,
+		ChunkSize:      meta.ChunkSize,
+		FileSize:       meta.FileSize,
+		ReceivedChunks: received,
+		MissingChunks:  missingChunkRanges(received, meta.TotalChunks),
+		BytesReceived:  meta.bytesReceived(),
+		CreatedAt:      meta.CreatedAt,
+		UpdatedAt:      updatedAt,
+		ExpiresAt:      meta.ExpiresAt,
+		FileID:         meta.FinalFileID,
+		Error:          meta.Error,
+	}, nil
+}
+
+// DownloadFile opens a revision of a complete file from the
+// blob store using the filename. Revisions are numbered over
+// the whole history of the file; a revision in trash is
+// reported as not found, one the caller may not read as
+// forbidden. The content is streamed by the returned reader
+// rather than loaded into memory.
+func (s *fileService) DownloadFile(ctx context.Context, filename string, revision int) (BlobReader, error) {
+	revisions, err := s.blobs.Revisions(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	if revision < 0 {
+		revision += len(revisions)
+	}
+	if revision < 0 || revision >= len(revisions) {
+		return nil, ErrBlobNotFound
+	}
+	info := revisions[revision]
+	if err := s.checkRevision(IdentityFromContext(ctx), info, PermissionRead); err != nil {
+		return nil, err
+	}
+	return s.blobs.OpenReaderByID(ctx, info.ID)
+}
+
+// ListFiles searches the blob store for files matching
+// the query and readable by the caller, returning one page
+// of results.
+func (s *fileService) ListFiles(ctx context.Context, query ListFilesQuery) (ListFilesPage, error) {
+	id := IdentityFromContext(ctx)
+	query.ReadableBy = &id
+	query.ReadableUnowned = s.openUnowned
+	return s.blobs.List(ctx, query)
+}
+
+// ListRevisions lists the stored revisions of a file
+// readable by the caller. Revisions are numbered from 0 for
+// the oldest over the whole history, so revisions in trash or
+// hidden from the caller leave gaps rather than shifting the
+// numbers of the others.
+func (s *fileService) ListRevisions(ctx context.Context, filename string) ([]FileRevision, error) {
+	infos, err := s.blobs.Revisions(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	id := IdentityFromContext(ctx)
+	revisions := []FileRevision{}
+	hidden := ErrBlobNotFound
+	for i, info := range infos {
+		if err := s.checkRevision(id, info, PermissionRead); err != nil {
+			if errors.Is(err, ErrForbidden) {
+				hidden = err
+			}
+			continue
+		}
+		revisions = append(revisions, FileRevision{Revision: i, BlobInfo: info})
+	}
+	if len(revisions) == 0 {
+		return nil, hidden
+	}
+	return revisions, nil
 }
 
-// DownloadFile retrieves a complete file from GridFS
-// using the filename and returns its content in memory.
-func (s *fileService) DownloadFile(ctx context.Context, filename string) ([]byte, error) {
-	fileStream, err := s.fsBucket.OpenDownloadStreamByName(filename)
+// PruneRevisions deletes all but the keep most recent
+// revisions of a file from the blob store, counting the
+// revisions in trash like DownloadFile does. Nothing is
+// deleted unless the caller may delete every pruned revision.
+func (s *fileService) PruneRevisions(ctx context.Context, filename string, keep int) ([]string, error) {
+	if keep < 1 {
+		return nil, ErrInvalidRetention
+	}
+
+	infos, err := s.blobs.Revisions(ctx, filename)
 	if err != nil {
 		return nil, err
 	}
-	defer fileStream.Close()
+	if len(infos) == 0 {
+		return nil, ErrBlobNotFound
+	}
+	pruned := infos[:max(len(infos)-keep, 0)]
+
+	id := IdentityFromContext(ctx)
+	for _, info := range pruned {
+		if !s.allows(info.Metadata, id, PermissionDelete) {
+			return nil, ErrForbidden
+		}
+	}
+
+	deleted := []string{}
+	for _, info := range pruned {
+		if err := s.deleteFile(ctx, info); err != nil && !errors.Is(err, ErrBlobNotFound) {
+			return deleted, err
+		}
+		deleted = append(deleted, info.ID)
+	}
+	return deleted, nil
+}
 
-	data, err := io.ReadAll(fileStream)
+// DownloadFileByID opens the stored file with the given ID
+// from the blob store, streaming its content. Files in trash
+// are reported as not found, files the caller may not read
+// as forbidden.
+func (s *fileService) DownloadFileByID(ctx context.Context, fileID string) (BlobReader, error) {
+	file, err := s.blobs.OpenReaderByID(ctx, fileID)
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
+	if file.Info().Metadata.Trashed() {
+		return nil, errors.Join(ErrBlobNotFound, file.Close())
+	}
+	if !s.canRead(ctx, file.Info()) {
+		return nil, errors.Join(ErrForbidden, file.Close())
+	}
+	return file, nil
 }
 
-// safeOpenChunk sanitizes the file path and safely opens a chunk file
-// from disk. It prevents path traversal using filepath.Base.
-func safeOpenChunk(baseDir, sessionID string, chunkNum int) (*os.File, error) {
-	chunkFilename := fmt.Sprintf("%d.chunk", chunkNum)
-	dir := filepath.Join(baseDir, filepath.Base(sessionID))
-	safePath := filepath.Join(dir, filepath.Base(chunkFilename))
-	// #nosec G304 -- path sanitized with filepath.Base
-	return os.Open(safePath)
+// GetFileInfo returns the description of the stored file
+// with the given ID, unless it is in trash or not readable
+// by the caller.
+func (s *fileService) GetFileInfo(ctx context.Context, fileID string) (BlobInfo, error) {
+	info, err := s.blobs.StatByID(ctx, fileID)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if info.Metadata.Trashed() {
+		return BlobInfo{}, ErrBlobNotFound
+	}
+	if !s.canRead(ctx, info) {
+		return BlobInfo{}, ErrForbidden
+	}
+	return info, nil
 }
 
-// removedProcessedChunks deletes all buffered chunk files
-// for the specified upload session from the local temp directory.
-func (s *fileService) removedProcessedChunks(sessionID string) error {
-	return os.RemoveAll(filepath.Join(s.tempDir, sessionID))
+// canRead reports whether the caller may read the file, either
+// by its ACL or through a pre-signed download URL for it.
+func (s *fileService) canRead(ctx context.Context, info BlobInfo) bool {
+	if signed, ok := SignedDownloadFromContext(ctx); ok && signed.FileID == info.ID {
+		return true
+	}
+	return s.allows(info.Metadata, IdentityFromContext(ctx), PermissionRead)
+}
+
+// checkRevision reports a revision in trash as not found and
+// one on which the identity lacks permission as forbidden.
+func (s *fileService) checkRevision(id Identity, info BlobInfo, permission string) error {
+	if info.Metadata.Trashed() {
+		return ErrBlobNotFound
+	}
+	if !s.allows(info.Metadata, id, permission) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// findSession loads the metadata of an upload session.
+func (s *fileService) findSession(ctx context.Context, sessionID string) (UploadMetadata, error) {
+	meta := UploadMetadata{}
+	err := s.metadata.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&meta)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return meta, ErrSessionNotFound
+	}
+	return meta, err
+}
+
+// transitionSession atomically moves the session from status
+// from to status to, applying the additional fields in set, and
+// returns the updated metadata. If the session is not in status
+// from, it returns the current metadata and an error wrapping
+// ErrSessionNotInProgress that reports the current status.
+func (s *fileService) transitionSession(ctx context.Context, sessionID, from, to string, set bson.M) (UploadMetadata, error) {
+	fields := bson.M{"status": to, "updated_at": time.Now()}
+	maps.Copy(fields, set)
+
+	meta := UploadMetadata{}
+	err := s.metadata.FindOneAndUpdate(ctx,
+		bson.M{"_id": sessionID, "status": from},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&meta)
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return meta, err
+	}
+
+	meta, err = s.findSession(ctx, sessionID)
+	if err != nil {
+		return meta, err
+	}
+	return meta, withDetails(ErrSessionNotInProgress, map[string]any{"status": meta.Status})
+}
+
+// removedProcessedChunks deletes all staged chunks
+// for the specified upload session.
+func (s *fileService) removedProcessedChunks(ctx context.Context, sessionID string) error {
+	return s.stager.RemoveSession(ctx, sessionID)
+}
+
+// missingChunkRanges returns the ranges of chunk numbers in
+// [0, totalChunks) absent from received, which must be sorted.
+func missingChunkRanges(received []int, totalChunks int) []ChunkRange {
+	missing := []ChunkRange{}
+	next := 0
+	for _, n := range received {
+		if n >= totalChunks {
+			break
+		}
+		if n > next {
+			missing = append(missing, ChunkRange{Start: next, End: n - 1})
+		}
+		next = max(next, n+1)
+	}
+	if next < totalChunks {
+		missing = append(missing, ChunkRange{Start: next, End: totalChunks - 1})
+	}
+	return missing
 }
@@ -0,0 +1,90 @@
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMissingChunkRanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		received    []int
+		totalChunks int
+		want        []ChunkRange
+	}{
+		{"nothing received", nil, 4, []ChunkRange{{0, 3}}},
+		{"everything received", []int{0, 1, 2, 3}, 4, []ChunkRange{}},
+		{"first chunk missing", []int{1, 2, 3}, 4, []ChunkRange{{0, 0}}},
+		{"last chunks missing", []int{0, 1}, 4, []ChunkRange{{2, 3}}},
+		{"gaps", []int{1, 4, 5, 8}, 10, []ChunkRange{{0, 0}, {2, 3}, {6, 7}, {9, 9}}},
+		{"duplicates", []int{0, 0, 2, 2}, 3, []ChunkRange{{1, 1}}},
+		{"chunks beyond the total ignored", []int{0, 5, 6}, 3, []ChunkRange{{1, 2}}},
+		{"empty session", nil, 0, []ChunkRange{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missingChunkRanges(tt.received, tt.totalChunks); !slices.Equal(got, tt.want) {
+				t.Errorf("missingChunkRanges(%v, %d) = %v, want %v", tt.received, tt.totalChunks, got, tt.want)
+			}
+		})
+	}
+}
+
+// abortingStager aborts the session right after a chunk was written, like
+// an abort request racing with the upload.
+type abortingStager struct {
+	ChunkStager
+	abort func()
+}
+
+// WriteChunk stages the chunk, then aborts the session.
+func (s abortingStager) WriteChunk(ctx context.Context, sessionID string, chunkNum int, data io.Reader) (int64, error) {
+	n, err := s.ChunkStager.WriteChunk(ctx, sessionID, chunkNum, data)
+	s.abort()
+	return n, err
+}
+
+func TestUploadChunkAbortedWhileStaging(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("chunk removed", func(mt *mtest.T) {
+		var session bson.D
+		raw, err := bson.Marshal(UploadMetadata{
+			ID: "session-1", Owner: "alice", Status: SessionInProgress,
+			TotalChunks: 2, ChunkSize: 4, FileSize: 8,
+		})
+		if err != nil {
+			mt.Fatal(err)
+		}
+		if err := bson.Unmarshal(raw, &session); err != nil {
+			mt.Fatal(err)
+		}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "files.upload_metadata", mtest.FirstBatch, session))
+
+		stager := NewDiskChunkStager(mt.TempDir())
+		s := &fileService{
+			metadata: mt.Coll,
+			stager: abortingStager{ChunkStager: stager, abort: func() {
+				// The session is no longer in progress, the update matches nothing.
+				mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}})
+			}},
+			ttl: time.Hour,
+		}
+
+		ctx := ContextWithPrincipal(context.Background(), "alice")
+		err = s.UploadChunk(ctx, "session-1", 0, ChunkChecksum{}, strings.NewReader("data"))
+		if !errors.Is(err, ErrSessionNotInProgress) {
+			mt.Fatalf("UploadChunk() = %v, want %v", err, ErrSessionNotInProgress)
+		}
+		if _, err := stager.OpenChunk(ctx, "session-1", 0); !errors.Is(err, ErrChunkNotFound) {
+			mt.Errorf("OpenChunk() after the aborted upload = %v, want %v", err, ErrChunkNotFound)
+		}
+	})
+}
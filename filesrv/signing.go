@@ -0,0 +1,205 @@
+// Package filesrv implements pre-signed URLs: links carrying an expiry and
+// an HMAC signature over their query parameters, letting callers without
+// credentials access exactly one file.
+package filesrv
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"mime"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters of pre-signed URLs.
+const (
+	signatureParam   = "signature"   // HMAC-SHA256 of the other parameters, base64url encoded
+	expiresParam     = "expires"     // Expiry as Unix seconds
+	clientIPParam    = "ip"          // Client IP the URL is bound to
+	dispositionParam = "disposition" // Content-Disposition sent with the download
+	tenantParam      = "tenant"      // Tenant the URL is valid for
+)
+
+// signedDownloadPurpose separates the signatures of download URLs from
+// those of other pre-signed URLs using the same keys.
+const signedDownloadPurpose = "download"
+
+// URLSigner signs and verifies the query parameters of pre-signed URLs
+// with HMAC-SHA256. The first key signs, every key verifies, so keys can
+// be rotated without breaking URLs issued with the previous one.
+type URLSigner struct {
+	keys [][]byte // Signing key first, followed by keys still accepted
+}
+
+// NewURLSigner creates a URLSigner signing with the first key and
+// accepting signatures of all keys. It returns nil without keys, which
+// disables pre-signed URLs.
+func NewURLSigner(keys ...[]byte) *URLSigner {
+	if len(keys) == 0 {
+		return nil
+	}
+	return &URLSigner{keys: keys}
+}
+
+// Sign sets the expiry and the signature of params for the purpose.
+func (s *URLSigner) Sign(purpose string, params url.Values, expiresAt time.Time) {
+	params.Del(signatureParam)
+	params.Set(expiresParam, strconv.FormatInt(expiresAt.Unix(), 10))
+	params.Set(signatureParam, base64.RawURLEncoding.EncodeToString(s.mac(s.keys[0], purpose, params)))
+}
+
+// Verify checks that params carry a valid signature for the purpose by
+// any of the keys and did not expire. Failures wrap ErrInvalidSignature.
+func (s *URLSigner) Verify(purpose string, params url.Values, now time.Time) error {
+	if s == nil {
+		return withDetails(ErrInvalidSignature, "pre-signed URLs are not enabled")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(params.Get(signatureParam))
+	if err != nil || len(sig) == 0 {
+		return withDetails(ErrInvalidSignature, "malformed signature")
+	}
+
+	valid := false
+	for _, key := range s.keys {
+		if hmac.Equal(sig, s.mac(key, purpose, params)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	expires, err := strconv.ParseInt(params.Get(expiresParam), 10, 64)
+	if err != nil || !now.Before(time.Unix(expires, 0)) {
+		return withDetails(ErrInvalidSignature, "URL expired")
+	}
+	return nil
+}
+
+// mac computes the signature of params, except the signature itself, for
+// the purpose. url.Values.Encode sorts the parameters, making the input
+// independent of their order in the URL.
+func (s *URLSigner) mac(key []byte, purpose string, params url.Values) []byte {
+	signed := url.Values{}
+	for k, v := range params {
+		if k != signatureParam {
+			signed[k] = v
+		}
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(purpose + "\n" + signed.Encode()))
+	return h.Sum(nil)
+}
+
+// DownloadURLOptions restrict a pre-signed download URL.
+type DownloadURLOptions struct {
+	TTL         time.Duration `json:"ttl"`                   // Lifetime of the URL, the configured default if zero
+	ClientIP    string        `json:"client_ip,omitempty"`   // Only clients with this IP may use the URL, any if empty
+	Disposition string        `json:"disposition,omitempty"` // Content-Disposition of the download, e.g. "inline"
+}
+
+// SignedURL is a pre-signed URL and its expiry.
+type SignedURL struct {
+	URL       string    `json:"url"`        // Pre-signed URL, relative unless a base URL is configured
+	ExpiresAt time.Time `json:"expires_at"` // Time after which the URL is rejected
+}
+
+// SignedDownload is a verified pre-signed download URL.
+type SignedDownload struct {
+	FileID      string // ID of the file the URL grants access to
+	Disposition string // Content-Disposition override, empty for the default
+	Tenant      string // Tenant owning the file, empty without multi-tenancy
+}
+
+// signedDownloadContextKey is the context key holding the SignedDownload
+// verified by the transport.
+type signedDownloadContextKey struct{}
+
+// ContextWithSignedDownload returns a copy of ctx carrying a verified
+// pre-signed download URL, granting read access to its file.
+func ContextWithSignedDownload(ctx context.Context, d SignedDownload) context.Context {
+	return context.WithValue(ctx, signedDownloadContextKey{}, d)
+}
+
+// SignedDownloadFromContext returns the verified pre-signed download URL
+// stored in ctx, if any.
+func SignedDownloadFromContext(ctx context.Context) (SignedDownload, bool) {
+	d, ok := ctx.Value(signedDownloadContextKey{}).(SignedDownload)
+	return d, ok
+}
+
+// VerifyDownloadURL checks the signature, expiry and client IP binding of
+// the query of a pre-signed download URL used by remoteIP.
+func (s *URLSigner) VerifyDownloadURL(params url.Values, remoteIP string, now time.Time) (SignedDownload, error) {
+	if err := s.Verify(signedDownloadPurpose, params, now); err != nil {
+		return SignedDownload{}, err
+	}
+	if ip := params.Get(clientIPParam); ip != "" && !net.ParseIP(ip).Equal(net.ParseIP(remoteIP)) {
+		return SignedDownload{}, withDetails(ErrInvalidSignature, "URL is bound to another client IP")
+	}
+	return SignedDownload{
+		FileID:      params.Get("file_id"),
+		Disposition: params.Get(dispositionParam),
+		Tenant:      params.Get(tenantParam),
+	}, nil
+}
+
+// SignDownloadURL issues a pre-signed URL downloading the file
+// with the given ID without credentials. The caller needs read
+// permission on the file.
+func (s *fileService) SignDownloadURL(ctx context.Context, fileID string, opts DownloadURLOptions) (SignedURL, error) {
+	if s.signer == nil {
+		return SignedURL{}, ErrSigningDisabled
+	}
+	ttl, err := s.signedTTL(opts.TTL)
+	if err != nil {
+		return SignedURL{}, err
+	}
+	if _, err := s.GetFileInfo(ctx, fileID); err != nil {
+		return SignedURL{}, err
+	}
+
+	params := url.Values{"file_id": {fileID}}
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		params.Set(tenantParam, tenant)
+	}
+	if opts.ClientIP != "" {
+		ip := net.ParseIP(opts.ClientIP)
+		if ip == nil {
+			return SignedURL{}, withDetails(ErrInvalidRequest, "invalid client ip")
+		}
+		params.Set(clientIPParam, ip.String())
+	}
+	if opts.Disposition != "" {
+		if _, _, err := mime.ParseMediaType(opts.Disposition); err != nil {
+			return SignedURL{}, withDetails(ErrInvalidRequest, "invalid disposition")
+		}
+		params.Set(dispositionParam, opts.Disposition)
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second).UTC()
+	s.signer.Sign(signedDownloadPurpose, params, expiresAt)
+	return SignedURL{
+		URL:       s.signedURLBase + "/download?" + params.Encode(),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// signedTTL returns the lifetime of a pre-signed URL or grant,
+// defaulting and capping the requested one.
+func (s *fileService) signedTTL(ttl time.Duration) (time.Duration, error) {
+	switch {
+	case ttl < 0:
+		return 0, withDetails(ErrInvalidRequest, "ttl must not be negative")
+	case ttl == 0:
+		return s.signedURLTTL, nil
+	case s.maxSignedURLTTL > 0 && ttl > s.maxSignedURLTTL:
+		return 0, withDetails(ErrInvalidRequest, "ttl exceeds "+s.maxSignedURLTTL.String())
+	}
+	return ttl, nil
+}
@@ -0,0 +1,158 @@
+package filesrv
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestURLSignerVerifyDownloadURL(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	oldKey, newKey := []byte("old-key-0123456789abcdef0123456789"), []byte("new-key-0123456789abcdef0123456789")
+
+	signed := func(signer *URLSigner, expiresAt time.Time, ip string) url.Values {
+		params := url.Values{"file_id": {"file-1"}, tenantParam: {"acme"}}
+		if ip != "" {
+			params.Set(clientIPParam, ip)
+		}
+		signer.Sign(signedDownloadPurpose, params, expiresAt)
+		return params
+	}
+
+	tests := []struct {
+		name     string
+		verifier *URLSigner
+		params   func() url.Values
+		remoteIP string
+		want     error
+	}{
+		{
+			name:     "valid",
+			verifier: NewURLSigner(newKey),
+			params:   func() url.Values { return signed(NewURLSigner(newKey), now.Add(time.Hour), "") },
+			remoteIP: "192.0.2.1",
+		},
+		{
+			name:     "signed with a rotated key",
+			verifier: NewURLSigner(newKey, oldKey),
+			params:   func() url.Values { return signed(NewURLSigner(oldKey), now.Add(time.Hour), "") },
+			remoteIP: "192.0.2.1",
+		},
+		{
+			name:     "bound to the client IP",
+			verifier: NewURLSigner(newKey),
+			params:   func() url.Values { return signed(NewURLSigner(newKey), now.Add(time.Hour), "192.0.2.1") },
+			remoteIP: "192.0.2.1",
+		},
+		{
+			name:     "expired",
+			verifier: NewURLSigner(newKey),
+			params:   func() url.Values { return signed(NewURLSigner(newKey), now, "") },
+			remoteIP: "192.0.2.1",
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "tampered file ID",
+			verifier: NewURLSigner(newKey),
+			params: func() url.Values {
+				params := signed(NewURLSigner(newKey), now.Add(time.Hour), "")
+				params.Set("file_id", "file-2")
+				return params
+			},
+			remoteIP: "192.0.2.1",
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "extended expiry",
+			verifier: NewURLSigner(newKey),
+			params: func() url.Values {
+				params := signed(NewURLSigner(newKey), now.Add(time.Hour), "")
+				params.Set(expiresParam, "99999999999")
+				return params
+			},
+			remoteIP: "192.0.2.1",
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "tampered tenant",
+			verifier: NewURLSigner(newKey),
+			params: func() url.Values {
+				params := signed(NewURLSigner(newKey), now.Add(time.Hour), "")
+				params.Set(tenantParam, "globex")
+				return params
+			},
+			remoteIP: "192.0.2.1",
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "malformed signature",
+			verifier: NewURLSigner(newKey),
+			params: func() url.Values {
+				params := signed(NewURLSigner(newKey), now.Add(time.Hour), "")
+				params.Set(signatureParam, "not base64!")
+				return params
+			},
+			remoteIP: "192.0.2.1",
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "missing signature",
+			verifier: NewURLSigner(newKey),
+			params: func() url.Values {
+				params := signed(NewURLSigner(newKey), now.Add(time.Hour), "")
+				params.Del(signatureParam)
+				return params
+			},
+			remoteIP: "192.0.2.1",
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "unknown key",
+			verifier: NewURLSigner(newKey),
+			params:   func() url.Values { return signed(NewURLSigner(oldKey), now.Add(time.Hour), "") },
+			remoteIP: "192.0.2.1",
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "used from another client IP",
+			verifier: NewURLSigner(newKey),
+			params:   func() url.Values { return signed(NewURLSigner(newKey), now.Add(time.Hour), "192.0.2.1") },
+			remoteIP: "192.0.2.2",
+			want:     ErrInvalidSignature,
+		},
+		{
+			name:     "signing disabled",
+			verifier: NewURLSigner(),
+			params:   func() url.Values { return signed(NewURLSigner(newKey), now.Add(time.Hour), "") },
+			remoteIP: "192.0.2.1",
+			want:     ErrInvalidSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := tt.verifier.VerifyDownloadURL(tt.params(), tt.remoteIP, now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyDownloadURL() = %v, want %v", err, tt.want)
+			}
+			if err == nil && (d.FileID != "file-1" || d.Tenant != "acme") {
+				t.Errorf("VerifyDownloadURL() = %+v, want file-1 of acme", d)
+			}
+		})
+	}
+}
+
+func TestURLSignerPurpose(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	signer := NewURLSigner([]byte("key-0123456789abcdef0123456789abcd"))
+
+	params := url.Values{"file_id": {"file-1"}}
+	signer.Sign("other", params, now.Add(time.Hour))
+
+	if err := signer.Verify(signedDownloadPurpose, params, now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Verify() of a signature for another purpose = %v, want %v", err, ErrInvalidSignature)
+	}
+	if err := signer.Verify("other", params, now); err != nil {
+		t.Errorf("Verify() = %v, want nil", err)
+	}
+}
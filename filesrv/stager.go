@@ -0,0 +1,66 @@
+// Package filesrv defines the ChunkStager abstraction used to buffer
+// uploaded chunks until an upload session is finalized.
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrChunkNotFound is returned by a ChunkStager when the requested chunk
+	// has not been staged.
+	ErrChunkNotFound = errors.New("chunk not found")
+
+	// ErrStagingLimitExceeded is returned by a ChunkStager when a chunk is
+	// larger than the backend is able to stage.
+	ErrStagingLimitExceeded = errors.New("chunk exceeds staging size limit")
+)
+
+// chunkCopyBufferSize bounds the memory used per in-flight chunk write.
+const chunkCopyBufferSize = 32 * 1024
+
+// chunkCopyBuffers recycles the buffers used to stream chunks to staging.
+var chunkCopyBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, chunkCopyBufferSize)
+		return &buf
+	},
+}
+
+// ChunkStager buffers the chunks of in-progress upload sessions. Sharing a
+// stager between replicas lets any replica serve any chunk or finalize
+// request of a session. Implementations must be safe for concurrent use.
+type ChunkStager interface {
+	// WriteChunk streams data into chunk chunkNum of the session, replacing
+	// any previously staged copy of the same chunk once data is fully read.
+	// It returns the number of bytes staged.
+	WriteChunk(ctx context.Context, sessionID string, chunkNum int, data io.Reader) (int64, error)
+
+	// OpenChunk opens a staged chunk for reading.
+	OpenChunk(ctx context.Context, sessionID string, chunkNum int) (io.ReadCloser, error)
+
+	// DeleteChunk deletes a staged chunk of the session. Deleting a chunk
+	// that is not staged is not an error.
+	DeleteChunk(ctx context.Context, sessionID string, chunkNum int) error
+
+	// RemoveSession deletes every staged chunk of the session.
+	RemoveSession(ctx context.Context, sessionID string) error
+
+	// Sessions lists the IDs of all sessions with staged data.
+	Sessions(ctx context.Context) ([]string, error)
+
+	// Chunks returns the size in bytes of every complete chunk staged for
+	// the session, keyed by chunk number. Leftovers of interrupted writes
+	// are discarded, so it must not run while the session receives chunks.
+	Chunks(ctx context.Context, sessionID string) (map[int]int64, error)
+}
+
+// copyChunk streams src into dst through a pooled, fixed-size buffer.
+func copyChunk(dst io.Writer, src io.Reader) (int64, error) {
+	buf := chunkCopyBuffers.Get().(*[]byte)
+	defer chunkCopyBuffers.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
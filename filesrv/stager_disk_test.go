@@ -0,0 +1,179 @@
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiskChunkStager(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	stager := NewDiskChunkStager(dir)
+
+	if _, err := stager.OpenChunk(ctx, "session-1", 0); !errors.Is(err, ErrChunkNotFound) {
+		t.Fatalf("OpenChunk() before WriteChunk = %v, want %v", err, ErrChunkNotFound)
+	}
+	for chunkNum, data := range []string{"hello, ", "world"} {
+		n, err := stager.WriteChunk(ctx, "session-1", chunkNum, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != int64(len(data)) {
+			t.Errorf("WriteChunk() = %d, want %d", n, len(data))
+		}
+	}
+
+	r, err := stager.OpenChunk(ctx, "session-1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "world" {
+		t.Errorf("OpenChunk() read %q, want %q", data, "world")
+	}
+
+	for range 2 {
+		if err := stager.DeleteChunk(ctx, "session-1", 1); err != nil {
+			t.Fatalf("DeleteChunk() = %v, want nil", err)
+		}
+	}
+	if _, err := stager.OpenChunk(ctx, "session-1", 1); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("OpenChunk() after DeleteChunk = %v, want %v", err, ErrChunkNotFound)
+	}
+
+	if err := stager.RemoveSession(ctx, "session-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "session-1")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("session directory still exists after RemoveSession: %v", err)
+	}
+	if _, err := stager.OpenChunk(ctx, "session-1", 0); !errors.Is(err, ErrChunkNotFound) {
+		t.Errorf("OpenChunk() after RemoveSession = %v, want %v", err, ErrChunkNotFound)
+	}
+}
+
+func TestDiskChunkStagerSessionPath(t *testing.T) {
+	dir := t.TempDir()
+	stager := NewDiskChunkStager(dir)
+
+	if _, err := stager.WriteChunk(context.Background(), "../../escaped", 3, strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped", "3.chunk")); err != nil {
+		t.Errorf("chunk not staged inside the stager directory: %v", err)
+	}
+}
+
+// failingReader returns data and then fails like an aborted request body.
+type failingReader struct {
+	data string
+}
+
+// Read returns the remaining data and fails once it is consumed.
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestDiskChunkStagerInterruptedWrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	stager := NewDiskChunkStager(dir)
+
+	if _, err := stager.WriteChunk(ctx, "session-1", 0, strings.NewReader("complete chunk")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stager.WriteChunk(ctx, "session-1", 0, &failingReader{data: "trunc"}); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("WriteChunk() = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// The staged copy is only replaced once the new one is complete.
+	r, err := stager.OpenChunk(ctx, "session-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	_ = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "complete chunk" {
+		t.Errorf("OpenChunk() read %q, want the previously staged chunk", data)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "session-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "0.chunk" {
+			t.Errorf("session directory holds %s, want only 0.chunk", entry.Name())
+		}
+	}
+}
+
+func TestDiskChunkStagerChunks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	stager := NewDiskChunkStager(dir)
+
+	if sessions, err := stager.Sessions(ctx); err != nil || len(sessions) != 0 {
+		t.Fatalf("Sessions() = %v, %v, want none", sessions, err)
+	}
+	for chunkNum, data := range map[int]string{0: "0123456789", 2: "0123"} {
+		if _, err := stager.WriteChunk(ctx, "session-1", chunkNum, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Leftovers of a crash during a write and a stray file.
+	sessionDir := filepath.Join(dir, "session-1")
+	for _, name := range []string{"1.chunk.123456.part", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(sessionDir, name), []byte("junk"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "stray-file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := stager.Sessions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sessions, []string{"session-1"}) {
+		t.Errorf("Sessions() = %v, want [session-1]", sessions)
+	}
+
+	chunks, err := stager.Chunks(ctx, "session-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(chunks, map[int]int64{0: 10, 2: 4}) {
+		t.Errorf("Chunks() = %v, want chunks 0 and 2 with their sizes", chunks)
+	}
+	if _, err := os.Stat(filepath.Join(sessionDir, "1.chunk.123456.part")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Chunks() kept the partial chunk: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(sessionDir, "notes.txt")); err != nil {
+		t.Errorf("Chunks() removed a file it does not own: %v", err)
+	}
+
+	if chunks, err := stager.Chunks(ctx, "unknown"); err != nil || len(chunks) != 0 {
+		t.Errorf("Chunks() of an unknown session = %v, %v, want none", chunks, err)
+	}
+}
@@ -0,0 +1,149 @@
+package filesrv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// stagedChunk is the document stored per chunk by the MongoDB stager.
+type stagedChunk struct {
+	ID        string    `bson:"_id"`        // <sessionID>/<chunkNum>
+	SessionID string    `bson:"session_id"` // Upload session the chunk belongs to
+	ChunkNum  int       `bson:"chunk"`      // Chunk number (0-based)
+	Data      []byte    `bson:"data"`       // Raw binary data of the chunk
+	StagedAt  time.Time `bson:"staged_at"`  // Time the chunk was last written
+}
+
+// maxMongoStagedChunkSize is the largest chunk the MongoDB stager accepts,
+// leaving headroom below the 16MB document limit.
+const maxMongoStagedChunkSize = 15 * 1024 * 1024
+
+// mongoChunkStager stages chunks as documents in a MongoDB collection so
+// that every replica connected to the same database sees them. Each chunk
+// must fit into a single document, see maxMongoStagedChunkSize.
+type mongoChunkStager struct {
+	chunks *mongo.Collection // Collection holding staged chunk documents
+}
+
+// NewMongoChunkStager creates a ChunkStager backed by the given collection
+// and ensures the index used to look up chunks by session exists.
+func NewMongoChunkStager(ctx context.Context, coll *mongo.Collection) (ChunkStager, error) {
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "session_id", Value: 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mongoChunkStager{chunks: coll}, nil
+}
+
+// WriteChunk reads the chunk, up to maxMongoStagedChunkSize bytes,
+// and upserts the chunk document.
+func (m *mongoChunkStager) WriteChunk(ctx context.Context, sessionID string, chunkNum int, data io.Reader) (int64, error) {
+	var buf bytes.Buffer
+	n, err := copyChunk(&buf, io.LimitReader(data, maxMongoStagedChunkSize+1))
+	if err != nil {
+		return 0, err
+	}
+	if n > maxMongoStagedChunkSize {
+		return 0, ErrStagingLimitExceeded
+	}
+
+	id := stagedChunkID(sessionID, chunkNum)
+	_, err = m.chunks.ReplaceOne(ctx,
+		bson.M{"_id": id},
+		stagedChunk{
+			ID:        id,
+			SessionID: sessionID,
+			ChunkNum:  chunkNum,
+			Data:      buf.Bytes(),
+			StagedAt:  time.Now(),
+		},
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// OpenChunk loads the chunk document and returns a reader over its data.
+func (m *mongoChunkStager) OpenChunk(ctx context.Context, sessionID string, chunkNum int) (io.ReadCloser, error) {
+	var chunk stagedChunk
+	err := m.chunks.FindOne(ctx, bson.M{"_id": stagedChunkID(sessionID, chunkNum)}).Decode(&chunk)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrChunkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(chunk.Data)), nil
+}
+
+// DeleteChunk deletes the chunk document.
+func (m *mongoChunkStager) DeleteChunk(ctx context.Context, sessionID string, chunkNum int) error {
+	_, err := m.chunks.DeleteOne(ctx, bson.M{"_id": stagedChunkID(sessionID, chunkNum)})
+	return err
+}
+
+// RemoveSession deletes all chunk documents of the session.
+func (m *mongoChunkStager) RemoveSession(ctx context.Context, sessionID string) error {
+	_, err := m.chunks.DeleteMany(ctx, bson.M{"session_id": sessionID})
+	return err
+}
+
+// Sessions lists the distinct sessions of the staged chunk documents.
+func (m *mongoChunkStager) Sessions(ctx context.Context) ([]string, error) {
+	values, err := m.chunks.Distinct(ctx, "session_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]string, 0, len(values))
+	for _, v := range values {
+		if id, ok := v.(string); ok {
+			sessions = append(sessions, id)
+		}
+	}
+	return sessions, nil
+}
+
+// Chunks reports the size of each chunk document of the session without
+// loading the chunk data. Documents are only written once complete, so
+// there are no leftovers to discard.
+func (m *mongoChunkStager) Chunks(ctx context.Context, sessionID string) (map[int]int64, error) {
+	cursor, err := m.chunks.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"session_id": sessionID}}},
+		{{Key: "$project", Value: bson.M{"chunk": 1, "size": bson.M{"$binarySize": "$data"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	chunks := map[int]int64{}
+	for cursor.Next(ctx) {
+		var chunk struct {
+			ChunkNum int   `bson:"chunk"`
+			Size     int64 `bson:"size"`
+		}
+		if err := cursor.Decode(&chunk); err != nil {
+			return nil, err
+		}
+		chunks[chunk.ChunkNum] = chunk.Size
+	}
+	return chunks, cursor.Err()
+}
+
+// stagedChunkID builds the document ID of a staged chunk.
+func stagedChunkID(sessionID string, chunkNum int) string {
+	return fmt.Sprintf("%s/%d", sessionID, chunkNum)
+}
@@ -0,0 +1,46 @@
+// Package filesrv reports statistics of the upload sessions of the
+// FileService, sampled by the instrumenting middleware.
+package filesrv
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Stats is a snapshot of the unfinished upload sessions.
+type Stats struct {
+	ActiveSessions int64 `bson:"active_sessions" json:"active_sessions"` // Sessions in progress or finalizing
+	StagedBytes    int64 `bson:"staged_bytes" json:"staged_bytes"`       // Bytes of the chunks staged for these sessions
+}
+
+// GetStats counts the sessions in progress or finalizing and
+// sums the lengths of their staged chunks as recorded in the
+// metadata, without touching the stager.
+func (s *fileService) GetStats(ctx context.Context) (Stats, error) {
+	chunkLengths := bson.M{"$map": bson.M{
+		"input": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$chunk_lengths", bson.M{}}}},
+		"in":    "$$this.v",
+	}}
+	cursor, err := s.metadata.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": bson.M{"$in": bson.A{SessionInProgress, SessionFinalizing}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":             nil,
+			"active_sessions": bson.M{"$sum": 1},
+			"staged_bytes":    bson.M{"$sum": bson.M{"$sum": chunkLengths}},
+		}}},
+	})
+	if err != nil {
+		return Stats{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var stats Stats
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&stats); err != nil {
+			return Stats{}, err
+		}
+	}
+	return stats, cursor.Err()
+}
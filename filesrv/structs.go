@@ -4,22 +4,54 @@
 package filesrv
 
 import (
+	"io"
 	"time"
+)
 
-	"go.mongodb.org/mongo-driver/bson/primitive"
+// Statuses of an upload session. A session is created in progress and
+// either aborted, expired, or finalized into a completed or failed upload.
+const (
+	SessionInProgress = "in_progress" // Accepting chunks
+	SessionFinalizing = "finalizing"  // Chunks are being assembled into the stored file
+	SessionCompleted  = "completed"   // The file was stored
+	SessionFailed     = "failed"      // The file could not be stored
+	SessionAborted    = "aborted"     // Cancelled by the client
+	SessionExpired    = "expired"     // Reclaimed after being idle for longer than the session TTL
 )
 
 // UploadMetadata represents the state of a file upload session,
 // stored in MongoDB to track progress and finalize uploads.
 type UploadMetadata struct {
-	ID             string              `bson:"_id"`                     // Unique session ID for the upload
-	Filename       string              `bson:"filename"`                // Original file name
-	TotalChunks    int                 `bson:"total_chunks"`            // Expected number of chunks
-	UploadedChunks []int               `bson:"uploaded_chunks"`         // Chunks successfully uploaded
-	ChunkSize      int                 `bson:"chunk_size"`              // Size of each chunk in bytes
-	Status         string              `bson:"status"`                  // Upload status: in_progress, completed, or aborted
-	CreatedAt      time.Time           `bson:"created_at"`              // Timestamp of session creation
-	FinalFileID    *primitive.ObjectID `bson:"final_file_id,omitempty"` // ID of the final GridFS file (if completed)
+	ID              string            `bson:"_id"`                        // Unique session ID for the upload
+	Filename        string            `bson:"filename"`                   // Original file name
+	Owner           string            `bson:"owner"`                      // Principal that started the upload
+	ContentType     string            `bson:"content_type"`               // MIME type of the file
+	TotalChunks     int               `bson:"total_chunks"`               // Expected number of chunks
+	UploadedChunks  []int             `bson:"uploaded_chunks"`            // Chunks successfully uploaded
+	ChunkDigests    map[string]string `bson:"chunk_digests,omitempty"`    // Digest ("<algorithm>:<hex>") per chunk number
+	ChunkLengths    map[string]int64  `bson:"chunk_lengths,omitempty"`    // Staged size in bytes per chunk number
+	ChunkSize       int               `bson:"chunk_size"`                 // Size of each chunk in bytes
+	FileSize        int64             `bson:"file_size"`                  // Total size of the file in bytes, declared at init
+	Status          string            `bson:"status"`                     // Upload status, one of the Session* constants
+	CreatedAt       time.Time         `bson:"created_at"`                 // Timestamp of session creation
+	UpdatedAt       time.Time         `bson:"updated_at"`                 // Timestamp of the last change to the session
+	ExpiresAt       time.Time         `bson:"expires_at"`                 // Time after which an in-progress session expires
+	KeepRevisions   int               `bson:"keep_revisions,omitempty"`   // Number of revisions of the file to retain, 0 to inherit
+	FinalizingSince time.Time         `bson:"finalizing_since,omitempty"` // Time the session was claimed for finalizing
+	PendingFileID   string            `bson:"pending_file_id,omitempty"`  // ID of the blob being written while finalizing
+	FinalFileID     string            `bson:"final_file_id,omitempty"`    // ID of the final stored blob (if completed)
+	SHA256          string            `bson:"sha256,omitempty"`           // Hex SHA-256 of the final file (if completed)
+	MD5             string            `bson:"md5,omitempty"`              // Hex MD5 of the final file (if completed)
+	Error           string            `bson:"error,omitempty"`            // Reason the file could not be stored (if failed)
+}
+
+// bytesReceived returns the total size of the chunks received so far.
+func (m UploadMetadata) bytesReceived() int64 {
+	var n int64
+	for _, length := range m.ChunkLengths {
+		n += length
+	}
+	return n
 }
 
 // AbortRequest is the payload to abort an upload session.
@@ -35,23 +67,61 @@ type FinalizeRequest struct {
 
 // FinalizeResponse contains the result of finalizing a file upload.
 type FinalizeResponse struct {
-	FileID string `json:"file_id"`       // ID of the finalized GridFS file
+	FileID string `json:"file_id"`       // ID of the finalized blob
+	Length int64  `json:"length"`        // Size of the stored file in bytes
+	SHA256 string `json:"sha256"`        // Hex SHA-256 of the stored file
+	MD5    string `json:"md5"`           // Hex MD5 of the stored file
 	Err    error  `json:"err,omitempty"` // Optional error
 }
 
+// UploadStatusRequest is the payload to query the progress of an upload
+// session.
+type UploadStatusRequest struct {
+	SessionID string `json:"session_id"` // Upload session to inspect
+}
+
+// ChunkRange is an inclusive range of chunk numbers.
+type ChunkRange struct {
+	Start int `json:"start"` // First chunk number of the range
+	End   int `json:"end"`   // Last chunk number of the range
+}
+
+// UploadStatus reports the progress of an upload session so that
+// clients can resume it by uploading only the missing chunks.
+type UploadStatus struct {
+	SessionID      string       `json:"session_id"`        // ID of the upload session
+	Filename       string       `json:"filename"`          // Name of the file being uploaded
+	Status         string       `json:"status"`            // Upload status, one of the Session* constants
+	TotalChunks    int          `json:"total_chunks"`      // Expected number of chunks
+	ChunkSize      int          `json:"chunk_size"`        // Size of each chunk in bytes
+	FileSize       int64        `json:"file_size"`         // Total size of the file in bytes, declared at init
+	ReceivedChunks []int        `json:"received_chunks"`   // Chunk numbers received, in ascending order
+	MissingChunks  []ChunkRange `json:"missing_chunks"`    // Ranges of chunk numbers still to upload
+	BytesReceived  int64        `json:"bytes_received"`    // Total size of the received chunks
+	CreatedAt      time.Time    `json:"created_at"`        // Timestamp of session creation
+	UpdatedAt      time.Time    `json:"updated_at"`        // Timestamp of the last change to the session
+	ExpiresAt      time.Time    `json:"expires_at"`        // Time after which an in-progress session expires
+	FileID         string       `json:"file_id,omitempty"` // ID of the stored file (if completed)
+	Error          string       `json:"error,omitempty"`   // Reason the file could not be stored (if failed)
+}
+
 // UploadChunkRequest is the request body for uploading a single chunk
 // as part of a multipart file upload.
 type UploadChunkRequest struct {
-	SessionID string `json:"session_id"` // ID of the upload session
-	ChunkNum  int    `json:"chunk"`      // Chunk number (0-based)
-	Data      []byte // Raw binary data of the chunk
+	SessionID string        `json:"session_id"` // ID of the upload session
+	ChunkNum  int           `json:"chunk"`      // Chunk number (0-based)
+	Checksum  ChunkChecksum // Optional client supplied digest of the chunk
+	Data      io.Reader     // Stream over the raw binary data of the chunk
 }
 
 // InitUploadRequest contains parameters to start a new upload session.
 type InitUploadRequest struct {
-	Filename    string `json:"filename"`     // Name of the file to be uploaded
-	TotalChunks int    `json:"total_chunks"` // Total number of expected chunks
-	ChunkSize   int    `json:"chunk_size"`   // Size of each chunk in bytes
+	Filename      string `json:"filename"`       // Name of the file to be uploaded
+	TotalChunks   int    `json:"total_chunks"`   // Total number of expected chunks
+	ChunkSize     int    `json:"chunk_size"`     // Size of each chunk in bytes
+	FileSize      int64  `json:"file_size"`      // Total size of the file in bytes
+	ContentType   string `json:"content_type"`   // Optional MIME type of the file
+	KeepRevisions int    `json:"keep_revisions"` // Optional number of revisions of the file to retain
 }
 
 // InitUploadResponse is returned after a new upload session is created.
@@ -66,14 +136,92 @@ type GenericResponse struct {
 	Err error `json:"err,omitempty"` // Optional error
 }
 
-// DownloadRequest represents a request to download a file by name.
+// DownloadRequest represents a request to download a file by name, or by
+// ID when FileID is set.
 type DownloadRequest struct {
-	Filename string `json:"filename"` // Name of the file to retrieve
+	Filename    string `json:"filename"`    // Name of the file to retrieve
+	Revision    int    `json:"revision"`    // Revision to retrieve, LatestRevision by default
+	FileID      string `json:"file_id"`     // ID of the stored file, takes precedence over Filename
+	Disposition string `json:"disposition"` // Content-Disposition override of a pre-signed URL
+}
+
+// FileRevision describes one stored revision of a filename.
+type FileRevision struct {
+	Revision int `json:"revision"` // Revision number, 0 for the oldest
+	BlobInfo
+}
+
+// ListRevisionsRequest is the payload to list the revisions of a file.
+type ListRevisionsRequest struct {
+	Filename string `json:"filename"` // Name of the file
+}
+
+// ListRevisionsResponse contains the revisions of a file, oldest first.
+type ListRevisionsResponse struct {
+	Filename  string         `json:"filename"`      // Name of the file
+	Revisions []FileRevision `json:"revisions"`     // Stored revisions
+	Err       error          `json:"err,omitempty"` // Optional error
+}
+
+// PruneRevisionsRequest is the payload to delete old revisions of a file.
+type PruneRevisionsRequest struct {
+	Filename string `json:"filename"` // Name of the file
+	Keep     int    `json:"keep"`     // Number of most recent revisions to retain
+}
+
+// PruneRevisionsResponse lists the revisions deleted by a prune.
+type PruneRevisionsResponse struct {
+	Deleted []string `json:"deleted"`       // IDs of the deleted files
+	Err     error    `json:"err,omitempty"` // Optional error
+}
+
+// FileIDRequest identifies a stored file by the ID returned from
+// FinalizeUpload.
+type FileIDRequest struct {
+	FileID string `json:"file_id"` // ID of the stored file
 }
 
 // DownloadResponse represents the response to a file download request,
-// containing the file data and its name.
+// containing a stream over the file content and its description.
 type DownloadResponse struct {
-	Filename string // Original file name
-	Data     []byte // Raw file content
+	Info        BlobInfo          // Name, size and ID of the stored file
+	Content     io.ReadSeekCloser // File content, closed by the transport once sent
+	Disposition string            // Content-Disposition override, the default attachment if empty
+}
+
+// ErrorResponse is the JSON body written for every failed request.
+type ErrorResponse struct {
+	Code    string `json:"code"`              // Stable, machine readable error code
+	Message string `json:"message"`           // Human readable description of the error
+	Details any    `json:"details,omitempty"` // Optional error specific information
+}
+
+// QuotaRequest identifies the owner whose quota is read or changed.
+type QuotaRequest struct {
+	Owner string `json:"owner"` // Owner of the quota, the caller if empty
+	Quota        // New limits, only used when setting a quota
+}
+
+// AccessRequest grants or revokes permissions on a stored file.
+type AccessRequest struct {
+	FileID string `json:"file_id"` // ID of the stored file
+	Grant         // Grantee and permissions
+}
+
+// PublicRequest changes whether a stored file is readable by everyone.
+type PublicRequest struct {
+	FileID string `json:"file_id"` // ID of the stored file
+	Public bool   `json:"public"`  // Whether every authenticated caller may read the file
+}
+
+// SignDownloadRequest asks for a pre-signed download URL of a stored file.
+type SignDownloadRequest struct {
+	FileID             string `json:"file_id"` // ID of the stored file
+	DownloadURLOptions        // Lifetime and restrictions of the URL
+}
+
+// SignUploadRequest asks for an upload grant for an upload session.
+type SignUploadRequest struct {
+	SessionID          string `json:"session_id"` // Upload session the grant is for
+	UploadGrantOptions        // Lifetime and limits of the grant
 }
@@ -0,0 +1,446 @@
+// Package filesrv implements multi-tenancy: every request is resolved to a
+// tenant, whose files and upload sessions live in storage of their own.
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"golang.org/x/sync/singleflight"
+)
+
+// tenantContextKey is the context key holding the tenant a request acts on.
+type tenantContextKey struct{}
+
+// ContextWithTenant returns a copy of ctx acting on the tenant.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant stored in ctx, or an empty string
+// if none was set.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey{}).(string)
+	return tenant
+}
+
+// TenantResolver extracts the tenant requested by an HTTP request from a
+// header or the subdomain of the host.
+type TenantResolver struct {
+	Header     string // Header naming the tenant, e.g. "X-Tenant-ID", empty to ignore headers
+	BaseDomain string // Domain below which the first label names the tenant, empty to ignore the host
+}
+
+// Resolve returns the tenant requested by r, the header taking precedence
+// over the subdomain, or an empty string if it names none.
+func (t TenantResolver) Resolve(r *http.Request) string {
+	if t.Header != "" {
+		if tenant := r.Header.Get(t.Header); tenant != "" {
+			return tenant
+		}
+	}
+	if t.BaseDomain == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(t.BaseDomain))
+	if !ok || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
+
+// ResolveTenant returns an endpoint middleware storing the tenant of the
+// request on the context. Pre-signed URLs and upload grants carry their
+// tenant. Otherwise the tenant claimed by the credentials is used; the one
+// requested through header or subdomain must match it. Credentials without
+// tenant, such as those issued before multi-tenancy, are pinned to
+// defaultTenant and rejected if there is none. It must run after
+// Authenticate.
+func ResolveTenant(defaultTenant string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request any) (any, error) {
+			tenant, err := resolveTenant(ctx, defaultTenant)
+			if err != nil {
+				return nil, err
+			}
+			return next(ContextWithTenant(ctx, tenant), request)
+		}
+	}
+}
+
+// resolveTenant picks the tenant of a request as described by
+// ResolveTenant.
+func resolveTenant(ctx context.Context, defaultTenant string) (string, error) {
+	if signed, ok := SignedDownloadFromContext(ctx); ok {
+		return signed.Tenant, nil
+	}
+	if grant, ok := UploadGrantFromContext(ctx); ok {
+		return grant.Tenant, nil
+	}
+
+	requested, _ := ctx.Value(requestedTenantContextKey).(string)
+	claimed := IdentityFromContext(ctx).Tenant
+	if claimed == "" {
+		if defaultTenant == "" {
+			return "", withDetails(ErrForbidden, "credentials are not bound to a tenant")
+		}
+		claimed = defaultTenant
+	}
+	if requested != "" && requested != claimed {
+		return "", withDetails(ErrForbidden, "credentials are for another tenant")
+	}
+	return claimed, nil
+}
+
+// TenantFactory builds the FileService of a tenant, or returns an error
+// wrapping ErrUnknownTenant if there is no such tenant.
+type TenantFactory func(ctx context.Context, tenant string) (FileService, error)
+
+// tenantRouter is a FileService routing every call to the service of the
+// tenant on the context. Services are built on first use and cached.
+type tenantRouter struct {
+	factory  TenantFactory          // Builds the service of a tenant
+	tenants  []string               // Tenants visited by background jobs
+	builds   singleflight.Group     // Deduplicates concurrent builds of a tenant
+	mu       sync.Mutex             // Guards services
+	services map[string]FileService // Services built so far, by tenant
+}
+
+// NewTenantRouter creates a FileService routing calls to the service of
+// the tenant stored on the context, built by factory on first use.
+// Background jobs called without tenant, ExpireSessions, ReconcileStaging,
+// GetStats and PurgeTrash, run for each of the given tenants.
+func NewTenantRouter(factory TenantFactory, tenants ...string) FileService {
+	return &tenantRouter{
+		factory:  factory,
+		tenants:  tenants,
+		services: map[string]FileService{},
+	}
+}
+
+// service returns the service of the tenant on the context.
+func (t *tenantRouter) service(ctx context.Context) (FileService, error) {
+	tenant := TenantFromContext(ctx)
+	if tenant == "" {
+		return nil, ErrTenantRequired
+	}
+	return t.serviceOf(ctx, tenant)
+}
+
+// serviceOf returns the cached service of the tenant, building it on
+// first use. The build runs outside the lock, shared by concurrent
+// callers of the same tenant, so a slow tenant does not hold up the
+// others. Failures are not cached, so the next call retries.
+func (t *tenantRouter) serviceOf(ctx context.Context, tenant string) (FileService, error) {
+	if svc, ok := t.cached(tenant); ok {
+		return svc, nil
+	}
+
+	// The build outlives the caller that started it, others may wait for it.
+	buildCtx := context.WithoutCancel(ctx)
+	result := t.builds.DoChan(tenant, func() (any, error) {
+		if svc, ok := t.cached(tenant); ok {
+			return svc, nil
+		}
+		svc, err := t.factory(buildCtx, tenant)
+		if err != nil {
+			return nil, err
+		}
+		t.mu.Lock()
+		t.services[tenant] = svc
+		t.mu.Unlock()
+		return svc, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(FileService), nil
+	}
+}
+
+// cached returns the service of the tenant if it was built already.
+func (t *tenantRouter) cached(tenant string) (FileService, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	svc, ok := t.services[tenant]
+	return svc, ok
+}
+
+// each calls task with the service of every tenant, or only of the one on
+// the context if set. Failing tenants do not stop the others.
+func (t *tenantRouter) each(ctx context.Context, task func(context.Context, FileService) error) error {
+	tenants := t.tenants
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		tenants = []string{tenant}
+	}
+
+	var errs []error
+	for _, tenant := range tenants {
+		tenantCtx := ContextWithTenant(ctx, tenant)
+		svc, err := t.serviceOf(tenantCtx, tenant)
+		if err == nil {
+			err = task(tenantCtx, svc)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// InitUpload starts the upload session in the storage of the tenant.
+func (t *tenantRouter) InitUpload(ctx context.Context, filename string, totalChunks int, chunkSize int, fileSize int64, contentType string, keepRevisions int) (string, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return "", err
+	}
+	return svc.InitUpload(ctx, filename, totalChunks, chunkSize, fileSize, contentType, keepRevisions)
+}
+
+// UploadChunk stages the chunk in the storage of the tenant.
+func (t *tenantRouter) UploadChunk(ctx context.Context, sessionID string, chunkNum int, checksum ChunkChecksum, data io.Reader) error {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.UploadChunk(ctx, sessionID, chunkNum, checksum, data)
+}
+
+// FinalizeUpload finalizes the upload session of the tenant.
+func (t *tenantRouter) FinalizeUpload(ctx context.Context, sessionID string) (BlobInfo, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return svc.FinalizeUpload(ctx, sessionID)
+}
+
+// AbortUpload aborts the upload session of the tenant.
+func (t *tenantRouter) AbortUpload(ctx context.Context, sessionID string) error {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.AbortUpload(ctx, sessionID)
+}
+
+// GetUploadStatus reports on the upload session of the tenant.
+func (t *tenantRouter) GetUploadStatus(ctx context.Context, sessionID string) (UploadStatus, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return UploadStatus{}, err
+	}
+	return svc.GetUploadStatus(ctx, sessionID)
+}
+
+// ExpireSessions expires stale sessions of every tenant and returns the
+// total number.
+func (t *tenantRouter) ExpireSessions(ctx context.Context) (int, error) {
+	total := 0
+	err := t.each(ctx, func(ctx context.Context, svc FileService) error {
+		expired, err := svc.ExpireSessions(ctx)
+		total += expired
+		return err
+	})
+	return total, err
+}
+
+// ReconcileStaging reconciles the staging of every tenant and returns the
+// combined report.
+func (t *tenantRouter) ReconcileStaging(ctx context.Context) (ReconcileReport, error) {
+	var total ReconcileReport
+	err := t.each(ctx, func(ctx context.Context, svc FileService) error {
+		report, err := svc.ReconcileStaging(ctx)
+		total.OrphanedSessions += report.OrphanedSessions
+		total.RepairedSessions += report.RepairedSessions
+		total.ChunksAdded += report.ChunksAdded
+		total.ChunksDropped += report.ChunksDropped
+		return err
+	})
+	return total, err
+}
+
+// GetStats sums the stats of every tenant.
+func (t *tenantRouter) GetStats(ctx context.Context) (Stats, error) {
+	var total Stats
+	err := t.each(ctx, func(ctx context.Context, svc FileService) error {
+		stats, err := svc.GetStats(ctx)
+		total.ActiveSessions += stats.ActiveSessions
+		total.StagedBytes += stats.StagedBytes
+		return err
+	})
+	return total, err
+}
+
+// DownloadFile opens a file of the tenant by name.
+func (t *tenantRouter) DownloadFile(ctx context.Context, filename string, revision int) (BlobReader, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return svc.DownloadFile(ctx, filename, revision)
+}
+
+// ListFiles lists the files of the tenant.
+func (t *tenantRouter) ListFiles(ctx context.Context, query ListFilesQuery) (ListFilesPage, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return ListFilesPage{}, err
+	}
+	return svc.ListFiles(ctx, query)
+}
+
+// TrashFile moves a file of the tenant to trash.
+func (t *tenantRouter) TrashFile(ctx context.Context, fileID string) (BlobInfo, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return svc.TrashFile(ctx, fileID)
+}
+
+// RestoreFile moves a file of the tenant out of trash.
+func (t *tenantRouter) RestoreFile(ctx context.Context, fileID string) (BlobInfo, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return svc.RestoreFile(ctx, fileID)
+}
+
+// PurgeFile permanently deletes a trashed file of the tenant.
+func (t *tenantRouter) PurgeFile(ctx context.Context, fileID string) error {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return err
+	}
+	return svc.PurgeFile(ctx, fileID)
+}
+
+// PurgeTrash purges the trash of every tenant and returns the total number
+// of purged files.
+func (t *tenantRouter) PurgeTrash(ctx context.Context, olderThan time.Time) (int, error) {
+	total := 0
+	err := t.each(ctx, func(ctx context.Context, svc FileService) error {
+		purged, err := svc.PurgeTrash(ctx, olderThan)
+		total += purged
+		return err
+	})
+	return total, err
+}
+
+// GetQuota returns the quota of an owner within the tenant.
+func (t *tenantRouter) GetQuota(ctx context.Context, owner string) (QuotaUsage, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	return svc.GetQuota(ctx, owner)
+}
+
+// SetQuota changes the quota of an owner within the tenant.
+func (t *tenantRouter) SetQuota(ctx context.Context, owner string, quota Quota) (QuotaUsage, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return QuotaUsage{}, err
+	}
+	return svc.SetQuota(ctx, owner, quota)
+}
+
+// ListRevisions lists the revisions of a file of the tenant.
+func (t *tenantRouter) ListRevisions(ctx context.Context, filename string) ([]FileRevision, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return svc.ListRevisions(ctx, filename)
+}
+
+// PruneRevisions prunes the revisions of a file of the tenant.
+func (t *tenantRouter) PruneRevisions(ctx context.Context, filename string, keep int) ([]string, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return svc.PruneRevisions(ctx, filename, keep)
+}
+
+// DownloadFileByID opens a file of the tenant by ID.
+func (t *tenantRouter) DownloadFileByID(ctx context.Context, fileID string) (BlobReader, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return svc.DownloadFileByID(ctx, fileID)
+}
+
+// GetFileInfo describes a file of the tenant.
+func (t *tenantRouter) GetFileInfo(ctx context.Context, fileID string) (BlobInfo, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return svc.GetFileInfo(ctx, fileID)
+}
+
+// GrantAccess changes the ACL of a file of the tenant.
+func (t *tenantRouter) GrantAccess(ctx context.Context, fileID string, grant Grant) (BlobInfo, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return svc.GrantAccess(ctx, fileID, grant)
+}
+
+// RevokeAccess changes the ACL of a file of the tenant.
+func (t *tenantRouter) RevokeAccess(ctx context.Context, fileID string, grant Grant) (BlobInfo, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return svc.RevokeAccess(ctx, fileID, grant)
+}
+
+// SetPublic changes the visibility of a file of the tenant.
+func (t *tenantRouter) SetPublic(ctx context.Context, fileID string, public bool) (BlobInfo, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return svc.SetPublic(ctx, fileID, public)
+}
+
+// SignDownloadURL signs a download URL for a file of the tenant.
+func (t *tenantRouter) SignDownloadURL(ctx context.Context, fileID string, opts DownloadURLOptions) (SignedURL, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return SignedURL{}, err
+	}
+	return svc.SignDownloadURL(ctx, fileID, opts)
+}
+
+// SignUploadGrant signs an upload grant for a session of the tenant.
+func (t *tenantRouter) SignUploadGrant(ctx context.Context, sessionID string, opts UploadGrantOptions) (SignedUploadGrant, error) {
+	svc, err := t.service(ctx)
+	if err != nil {
+		return SignedUploadGrant{}, err
+	}
+	return svc.SignUploadGrant(ctx, sessionID, opts)
+}
@@ -0,0 +1,165 @@
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTenantResolverResolve(t *testing.T) {
+	resolver := TenantResolver{Header: "X-Tenant-ID", BaseDomain: "files.example.com"}
+
+	tests := []struct {
+		name     string
+		resolver TenantResolver
+		host     string
+		header   string
+		want     string
+	}{
+		{"header", resolver, "files.example.com", "acme", "acme"},
+		{"header over subdomain", resolver, "globex.files.example.com", "acme", "acme"},
+		{"subdomain", resolver, "acme.files.example.com", "", "acme"},
+		{"subdomain with port", resolver, "acme.files.example.com:8088", "", "acme"},
+		{"subdomain in upper case", resolver, "ACME.Files.Example.com", "", "acme"},
+		{"nested subdomain", resolver, "a.acme.files.example.com", "", ""},
+		{"base domain", resolver, "files.example.com", "", ""},
+		{"other domain", resolver, "acme.example.org", "", ""},
+		{"lookalike domain", resolver, "acme.evilfiles.example.com", "", ""},
+		{"host ignored", TenantResolver{Header: "X-Tenant-ID"}, "acme.files.example.com", "", ""},
+		{"header ignored", TenantResolver{BaseDomain: "files.example.com"}, "files.example.com", "acme", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/files", nil)
+			r.Host = tt.host
+			if tt.header != "" {
+				r.Header.Set("X-Tenant-ID", tt.header)
+			}
+			if got := tt.resolver.Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveTenant(t *testing.T) {
+	requested := func(ctx context.Context, tenant string) context.Context {
+		return context.WithValue(ctx, requestedTenantContextKey, tenant)
+	}
+	acme := ContextWithIdentity(context.Background(), Identity{Principal: "alice", Tenant: "acme"})
+	unbound := ContextWithIdentity(context.Background(), Identity{Principal: "alice"})
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		defaultTenant string
+		want          string
+		wantErr       error
+	}{
+		{"claimed tenant", acme, "", "acme", nil},
+		{"claimed tenant requested", requested(acme, "acme"), "", "acme", nil},
+		{"other tenant requested", requested(acme, "globex"), "", "", ErrForbidden},
+		{"claimed tenant over default", acme, "globex", "acme", nil},
+		{"unbound credentials pinned to default", unbound, "acme", "acme", nil},
+		{"unbound credentials requesting default", requested(unbound, "acme"), "acme", "acme", nil},
+		{"unbound credentials requesting other tenant", requested(unbound, "globex"), "acme", "", ErrForbidden},
+		{"unbound credentials without default", unbound, "", "", ErrForbidden},
+		{"unbound credentials requesting without default", requested(unbound, "globex"), "", "", ErrForbidden},
+		{"signed download", ContextWithSignedDownload(requested(context.Background(), "globex"), SignedDownload{Tenant: "acme"}), "", "acme", nil},
+		{"upload grant", ContextWithUploadGrant(requested(context.Background(), "globex"), UploadGrant{Tenant: "acme"}), "", "acme", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveTenant(tt.ctx, tt.defaultTenant)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("resolveTenant() = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveTenant() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// tenantTestService reports the tenant it was built for as the ID of every
+// file. Other methods are not implemented.
+type tenantTestService struct {
+	FileService
+	tenant string
+}
+
+// GetFileInfo returns a file whose ID is the tenant of the service.
+func (s tenantTestService) GetFileInfo(context.Context, string) (BlobInfo, error) {
+	return BlobInfo{ID: s.tenant}, nil
+}
+
+func TestTenantRouter(t *testing.T) {
+	var builds atomic.Int32
+	router := NewTenantRouter(func(_ context.Context, tenant string) (FileService, error) {
+		builds.Add(1)
+		if tenant != "acme" && tenant != "globex" {
+			return nil, fmt.Errorf("tenant %q: %w", tenant, ErrUnknownTenant)
+		}
+		return tenantTestService{tenant: tenant}, nil
+	}, "acme", "globex")
+
+	// The endpoint as wired by MakeEndpoints for multi-tenancy.
+	getFileInfo := ResolveTenant("")(func(ctx context.Context, _ any) (any, error) {
+		return router.GetFileInfo(ctx, "file-1")
+	})
+	call := func(id Identity, requestedTenant string) (string, error) {
+		ctx := ContextWithIdentity(context.Background(), id)
+		if requestedTenant != "" {
+			ctx = context.WithValue(ctx, requestedTenantContextKey, requestedTenant)
+		}
+		resp, err := getFileInfo(ctx, nil)
+		if err != nil {
+			return "", err
+		}
+		return resp.(BlobInfo).ID, nil
+	}
+
+	tests := []struct {
+		name      string
+		id        Identity
+		requested string
+		want      string
+		wantErr   error
+	}{
+		{"acme credentials", Identity{Principal: "alice", Tenant: "acme"}, "", "acme", nil},
+		{"globex credentials", Identity{Principal: "bob", Tenant: "globex"}, "globex", "globex", nil},
+		{"acme credentials for globex", Identity{Principal: "alice", Tenant: "acme"}, "globex", "", ErrForbidden},
+		{"unbound credentials for globex", Identity{Principal: "alice"}, "globex", "", ErrForbidden},
+		{"unknown tenant", Identity{Principal: "eve", Tenant: "initech"}, "", "", ErrUnknownTenant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := call(tt.id, tt.requested)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetFileInfo() = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetFileInfo() served by tenant %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// acme and globex are built once, the unknown tenant on every call.
+	before := builds.Load()
+	if _, err := call(Identity{Principal: "alice", Tenant: "acme"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := call(Identity{Principal: "eve", Tenant: "initech"}, ""); !errors.Is(err, ErrUnknownTenant) {
+		t.Fatalf("GetFileInfo() = %v, want %v", err, ErrUnknownTenant)
+	}
+	if got := builds.Load() - before; got != 1 {
+		t.Errorf("factory called %d times, want 1 for the unknown tenant only", got)
+	}
+
+	if _, err := router.GetFileInfo(context.Background(), "file-1"); !errors.Is(err, ErrTenantRequired) {
+		t.Errorf("GetFileInfo() without tenant = %v, want %v", err, ErrTenantRequired)
+	}
+}
@@ -0,0 +1,95 @@
+// Package filesrv implements the trash bin of the FileService: stored files
+// are soft deleted into trash, from where they can be restored or purged.
+package filesrv
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TrashFile moves a live file to trash, recording when and
+// by whom it was deleted. Trashed files are hidden from
+// downloads and listings until restored. The caller needs
+// delete permission on the file.
+func (s *fileService) TrashFile(ctx context.Context, fileID string) (BlobInfo, error) {
+	id := IdentityFromContext(ctx)
+	return s.blobs.UpdateMetadata(ctx, fileID, func(meta *BlobMetadata) error {
+		if meta.Trashed() {
+			return ErrBlobNotFound
+		}
+		if !s.allows(*meta, id, PermissionDelete) {
+			return ErrForbidden
+		}
+		now := time.Now().UTC()
+		meta.DeletedAt = &now
+		meta.DeletedBy = id.Principal
+		return nil
+	})
+}
+
+// RestoreFile moves a file out of trash, making it live again.
+// The caller needs delete permission on the file.
+func (s *fileService) RestoreFile(ctx context.Context, fileID string) (BlobInfo, error) {
+	id := IdentityFromContext(ctx)
+	return s.blobs.UpdateMetadata(ctx, fileID, func(meta *BlobMetadata) error {
+		if !meta.Trashed() {
+			return ErrBlobNotFound
+		}
+		if !s.allows(*meta, id, PermissionDelete) {
+			return ErrForbidden
+		}
+		meta.DeletedAt = nil
+		meta.DeletedBy = ""
+		return nil
+	})
+}
+
+// PurgeFile permanently deletes a file that is in trash. The
+// caller needs delete permission on the file.
+func (s *fileService) PurgeFile(ctx context.Context, fileID string) error {
+	info, err := s.blobs.StatByID(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	if !info.Metadata.Trashed() {
+		return ErrBlobNotFound
+	}
+	if !s.allows(info.Metadata, IdentityFromContext(ctx), PermissionDelete) {
+		return ErrForbidden
+	}
+	return s.deleteFile(ctx, info)
+}
+
+// PurgeTrash permanently deletes every file moved to trash
+// before olderThan and returns how many were deleted.
+func (s *fileService) PurgeTrash(ctx context.Context, olderThan time.Time) (int, error) {
+	query := ListFilesQuery{
+		Trashed:       true,
+		DeletedBefore: olderThan,
+		Limit:         maxListLimit,
+	}
+
+	purged := 0
+	for {
+		page, err := s.blobs.List(ctx, query)
+		if err != nil {
+			return purged, err
+		}
+		for _, info := range page.Files {
+			// A file already deleted concurrently is skipped, not counted.
+			err := s.deleteFile(ctx, info)
+			switch {
+			case errors.Is(err, ErrBlobNotFound):
+			case err != nil:
+				return purged, err
+			default:
+				purged++
+			}
+		}
+		if page.NextCursor == "" {
+			return purged, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
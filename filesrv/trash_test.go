@@ -0,0 +1,63 @@
+package filesrv
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// racingBlobStore deletes a listed file before the caller gets to it,
+// as a concurrent purge would.
+type racingBlobStore struct {
+	BlobStore
+	deleteID string // file deleted after the first List
+}
+
+func (b *racingBlobStore) List(ctx context.Context, q ListFilesQuery) (ListFilesPage, error) {
+	page, err := b.BlobStore.List(ctx, q)
+	if err == nil && b.deleteID != "" {
+		err = b.BlobStore.Delete(ctx, b.deleteID)
+		b.deleteID = ""
+	}
+	return page, err
+}
+
+func TestPurgeTrashCountsDeletedFiles(t *testing.T) {
+	blobs, err := NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for range 3 {
+		w, err := blobs.OpenWriter(context.Background(), "report.pdf", BlobMetadata{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		_, err = blobs.UpdateMetadata(context.Background(), w.ID(), func(meta *BlobMetadata) error {
+			deletedAt := time.Now().Add(-time.Hour)
+			meta.DeletedAt = &deletedAt
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, w.ID())
+	}
+
+	s := &fileService{blobs: &racingBlobStore{BlobStore: blobs, deleteID: ids[1]}}
+	purged, err := s.PurgeTrash(context.Background(), time.Now())
+	if err != nil {
+		t.Fatalf("PurgeTrash: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("PurgeTrash purged %d files, want 2", purged)
+	}
+	for _, id := range ids {
+		if _, err := blobs.StatByID(context.Background(), id); err != ErrBlobNotFound {
+			t.Errorf("StatByID(%s) error = %v, want ErrBlobNotFound", id, err)
+		}
+	}
+}
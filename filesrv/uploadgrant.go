@@ -0,0 +1,164 @@
+// Package filesrv implements signed upload grants: tokens issued by a
+// trusted backend letting an untrusted client upload the chunks of one
+// session and finalize it, within size and content type limits.
+package filesrv
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// signedUploadPurpose separates the signatures of upload grants from
+// those of other pre-signed URLs using the same keys.
+const signedUploadPurpose = "upload"
+
+// UploadGrantOptions restrict a signed upload grant.
+type UploadGrantOptions struct {
+	TTL         time.Duration `json:"ttl"`                     // Lifetime of the grant, the configured default if zero
+	MaxFileSize int64         `json:"max_file_size,omitempty"` // Largest file the session may declare, its declared size if zero
+	ContentType string        `json:"content_type,omitempty"`  // Content type the session must declare, its declared type if empty
+}
+
+// SignedUploadGrant is an issued upload grant.
+type SignedUploadGrant struct {
+	Token     string    `json:"token"`      // Opaque token sent in the X-Upload-Token header
+	SessionID string    `json:"session_id"` // Upload session the token is valid for
+	ExpiresAt time.Time `json:"expires_at"` // Time after which the token is rejected
+}
+
+// UploadGrant is a verified signed upload grant.
+type UploadGrant struct {
+	SessionID   string // Upload session the grant is valid for
+	MaxFileSize int64  // Largest file size the session may declare
+	ContentType string // Content type the session must declare
+	Tenant      string // Tenant of the session, empty without multi-tenancy
+}
+
+// uploadGrantContextKey is the context key holding the UploadGrant
+// verified by the transport.
+type uploadGrantContextKey struct{}
+
+// ContextWithUploadGrant returns a copy of ctx carrying a verified upload
+// grant, limiting the request to the session of the grant.
+func ContextWithUploadGrant(ctx context.Context, g UploadGrant) context.Context {
+	return context.WithValue(ctx, uploadGrantContextKey{}, g)
+}
+
+// UploadGrantFromContext returns the verified upload grant stored in ctx,
+// if any.
+func UploadGrantFromContext(ctx context.Context) (UploadGrant, bool) {
+	g, ok := ctx.Value(uploadGrantContextKey{}).(UploadGrant)
+	return g, ok
+}
+
+// VerifyUploadGrant decodes the token of an upload grant and checks its
+// signature and expiry.
+func (s *URLSigner) VerifyUploadGrant(token string, now time.Time) (UploadGrant, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return UploadGrant{}, withDetails(ErrInvalidSignature, "malformed upload token")
+	}
+	params, err := url.ParseQuery(string(raw))
+	if err != nil {
+		return UploadGrant{}, withDetails(ErrInvalidSignature, "malformed upload token")
+	}
+	if err := s.Verify(signedUploadPurpose, params, now); err != nil {
+		return UploadGrant{}, err
+	}
+
+	maxSize, err := strconv.ParseInt(params.Get("max_file_size"), 10, 64)
+	if err != nil {
+		return UploadGrant{}, withDetails(ErrInvalidSignature, "malformed upload token")
+	}
+	return UploadGrant{
+		SessionID:   params.Get("session_id"),
+		MaxFileSize: maxSize,
+		ContentType: params.Get("content_type"),
+		Tenant:      params.Get(tenantParam),
+	}, nil
+}
+
+// allows checks that the session is the one of the grant and declares a
+// file within its limits.
+func (g UploadGrant) allows(meta UploadMetadata) error {
+	switch {
+	case g.SessionID != meta.ID:
+		return withDetails(ErrForbidden, "upload grant is for another session")
+	case meta.FileSize > g.MaxFileSize:
+		return withDetails(ErrFileTooLarge, map[string]any{"max_file_size": g.MaxFileSize})
+	case meta.ContentType != g.ContentType:
+		return withDetails(ErrForbidden, "content type not allowed by the upload grant")
+	}
+	return nil
+}
+
+// authorizeSession checks that the caller may act on the
+// upload session: through an upload grant for it, or by
+// owning it. Sessions created before owners were recorded
+// are open to every caller.
+func authorizeSession(ctx context.Context, meta UploadMetadata) error {
+	if grant, ok := UploadGrantFromContext(ctx); ok {
+		return grant.allows(meta)
+	}
+	if meta.Owner != "" && meta.Owner != PrincipalFromContext(ctx) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// SignUploadGrant issues a token letting a client without
+// credentials upload chunks to the session and finalize it.
+// The caller must own the session, which has to be in
+// progress and declare a file within the limits of opts.
+func (s *fileService) SignUploadGrant(ctx context.Context, sessionID string, opts UploadGrantOptions) (SignedUploadGrant, error) {
+	if s.signer == nil {
+		return SignedUploadGrant{}, ErrSigningDisabled
+	}
+	ttl, err := s.signedTTL(opts.TTL)
+	if err != nil {
+		return SignedUploadGrant{}, err
+	}
+	if opts.MaxFileSize < 0 {
+		return SignedUploadGrant{}, withDetails(ErrInvalidRequest, "max_file_size must not be negative")
+	}
+
+	meta, err := s.findSession(ctx, sessionID)
+	if err != nil {
+		return SignedUploadGrant{}, err
+	}
+	if err := authorizeSession(ctx, meta); err != nil {
+		return SignedUploadGrant{}, err
+	}
+	if meta.Status != SessionInProgress {
+		return SignedUploadGrant{}, withDetails(ErrSessionNotInProgress, map[string]any{"status": meta.Status})
+	}
+
+	grant := UploadGrant{
+		SessionID:   meta.ID,
+		MaxFileSize: cmp.Or(opts.MaxFileSize, meta.FileSize),
+		ContentType: cmp.Or(opts.ContentType, meta.ContentType),
+	}
+	if err := grant.allows(meta); err != nil {
+		return SignedUploadGrant{}, err
+	}
+
+	params := url.Values{
+		"session_id":    {grant.SessionID},
+		"max_file_size": {strconv.FormatInt(grant.MaxFileSize, 10)},
+		"content_type":  {grant.ContentType},
+	}
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		params.Set(tenantParam, tenant)
+	}
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second).UTC()
+	s.signer.Sign(signedUploadPurpose, params, expiresAt)
+	return SignedUploadGrant{
+		Token:     base64.RawURLEncoding.EncodeToString([]byte(params.Encode())),
+		SessionID: grant.SessionID,
+		ExpiresAt: expiresAt,
+	}, nil
+}
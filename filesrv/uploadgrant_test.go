@@ -0,0 +1,132 @@
+package filesrv
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestURLSignerVerifyUploadGrant(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	signer := NewURLSigner([]byte("key-0123456789abcdef0123456789abcd"))
+
+	token := func(purpose string, expiresAt time.Time, tamper func(url.Values)) string {
+		params := url.Values{
+			"session_id":    {"session-1"},
+			"max_file_size": {"1024"},
+			"content_type":  {"image/png"},
+		}
+		signer.Sign(purpose, params, expiresAt)
+		if tamper != nil {
+			tamper(params)
+		}
+		return base64.RawURLEncoding.EncodeToString([]byte(params.Encode()))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", token(signedUploadPurpose, now.Add(time.Hour), nil), nil},
+		{"expired", token(signedUploadPurpose, now, nil), ErrInvalidSignature},
+		{"download URL signature", token(signedDownloadPurpose, now.Add(time.Hour), nil), ErrInvalidSignature},
+		{"other session", token(signedUploadPurpose, now.Add(time.Hour), func(p url.Values) {
+			p.Set("session_id", "session-2")
+		}), ErrInvalidSignature},
+		{"raised size limit", token(signedUploadPurpose, now.Add(time.Hour), func(p url.Values) {
+			p.Set("max_file_size", "1048576")
+		}), ErrInvalidSignature},
+		{"other content type", token(signedUploadPurpose, now.Add(time.Hour), func(p url.Values) {
+			p.Set("content_type", "text/html")
+		}), ErrInvalidSignature},
+		{"not base64", "not a token!", ErrInvalidSignature},
+		{"empty", "", ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grant, err := signer.VerifyUploadGrant(tt.token, now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("VerifyUploadGrant() = %v, want %v", err, tt.want)
+			}
+			want := UploadGrant{SessionID: "session-1", MaxFileSize: 1024, ContentType: "image/png"}
+			if err == nil && grant != want {
+				t.Errorf("VerifyUploadGrant() = %+v, want %+v", grant, want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSession(t *testing.T) {
+	session := UploadMetadata{ID: "session-1", Owner: "alice", FileSize: 1024, ContentType: "image/png"}
+	grant := UploadGrant{SessionID: "session-1", MaxFileSize: 1024, ContentType: "image/png"}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		meta UploadMetadata
+		want error
+	}{
+		{
+			name: "owner",
+			ctx:  ContextWithPrincipal(context.Background(), "alice"),
+			meta: session,
+		},
+		{
+			name: "other principal",
+			ctx:  ContextWithPrincipal(context.Background(), "mallory"),
+			meta: session,
+			want: ErrForbidden,
+		},
+		{
+			name: "anonymous",
+			ctx:  context.Background(),
+			meta: session,
+			want: ErrForbidden,
+		},
+		{
+			name: "session without owner",
+			ctx:  ContextWithPrincipal(context.Background(), "mallory"),
+			meta: UploadMetadata{ID: "session-1"},
+		},
+		{
+			name: "grant for the session",
+			ctx:  ContextWithUploadGrant(context.Background(), grant),
+			meta: session,
+		},
+		{
+			name: "grant used on another session",
+			ctx:  ContextWithUploadGrant(context.Background(), grant),
+			meta: UploadMetadata{ID: "session-2", Owner: "alice", FileSize: 1024, ContentType: "image/png"},
+			want: ErrForbidden,
+		},
+		{
+			name: "grant overrides the principal",
+			ctx:  ContextWithUploadGrant(ContextWithPrincipal(context.Background(), "alice"), grant),
+			meta: UploadMetadata{ID: "session-2", Owner: "alice", FileSize: 1024, ContentType: "image/png"},
+			want: ErrForbidden,
+		},
+		{
+			name: "session larger than the grant",
+			ctx:  ContextWithUploadGrant(context.Background(), grant),
+			meta: UploadMetadata{ID: "session-1", Owner: "alice", FileSize: 1025, ContentType: "image/png"},
+			want: ErrFileTooLarge,
+		},
+		{
+			name: "content type not granted",
+			ctx:  ContextWithUploadGrant(context.Background(), grant),
+			meta: UploadMetadata{ID: "session-1", Owner: "alice", FileSize: 1024, ContentType: "text/html"},
+			want: ErrForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := authorizeSession(tt.ctx, tt.meta); !errors.Is(err, tt.want) {
+				t.Errorf("authorizeSession() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
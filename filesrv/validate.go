@@ -0,0 +1,82 @@
+// Package filesrv validates uploaded chunks against the upload session
+// declared in InitUpload before they are staged.
+package filesrv
+
+import "io"
+
+// validateChunk checks that the session accepts chunks and that chunkNum
+// lies within the chunks declared for it.
+func validateChunk(meta UploadMetadata, chunkNum int) error {
+	if meta.Status != SessionInProgress {
+		return ErrSessionNotInProgress
+	}
+	if chunkNum < 0 || chunkNum >= meta.TotalChunks {
+		return ErrChunkOutOfRange
+	}
+	return nil
+}
+
+// validateFileSize checks that a file of fileSize bytes splits into
+// exactly totalChunks chunks of chunkSize bytes. An empty file may be
+// uploaded as zero chunks or as a single empty chunk.
+func validateFileSize(totalChunks, chunkSize int, fileSize int64) error {
+	if chunkSize <= 0 || totalChunks < 0 || fileSize < 0 {
+		return ErrInvalidFileSize
+	}
+	expected := (fileSize + int64(chunkSize) - 1) / int64(chunkSize)
+	if int64(totalChunks) != expected && (fileSize != 0 || totalChunks != 1) {
+		return withDetails(ErrInvalidFileSize, map[string]any{"expected_total_chunks": expected})
+	}
+	return nil
+}
+
+// chunkSizeReader enforces the size of a chunk while it is streamed.
+// Non-final chunks must be exactly the session chunk size and the final
+// chunk exactly the rest of the declared file size. Violations are
+// reported instead of io.EOF, so stagers discard the chunk instead of
+// committing it.
+type chunkSizeReader struct {
+	r        io.Reader // underlying chunk stream
+	n        int64     // bytes read so far
+	size     int64     // expected size of the chunk
+	max      int64     // chunk size declared for the session
+	overflow error     // error reported when the chunk exceeds max
+}
+
+// newChunkSizeReader wraps the stream of chunk chunkNum of the session
+// with a reader enforcing its size.
+func newChunkSizeReader(r io.Reader, meta UploadMetadata, chunkNum int) *chunkSizeReader {
+	c := &chunkSizeReader{
+		r:        r,
+		size:     int64(meta.ChunkSize),
+		max:      int64(meta.ChunkSize),
+		overflow: ErrChunkSizeMismatch,
+	}
+	if chunkNum == meta.TotalChunks-1 {
+		c.size = meta.FileSize - int64(meta.TotalChunks-1)*int64(meta.ChunkSize)
+		c.overflow = ErrChunkTooLarge
+	}
+	return c
+}
+
+// Read reads from the underlying stream, failing as soon as the chunk
+// grows beyond its expected size or ends short of it.
+func (c *chunkSizeReader) Read(p []byte) (int, error) {
+	// Read at most one byte past the chunk size to detect oversized
+	// chunks without consuming the rest of the stream.
+	if remaining := c.max - c.n + 1; int64(len(p)) > remaining {
+		p = p[:max(remaining, 0)]
+	}
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	switch {
+	case c.n > c.max:
+		return n, c.overflow
+	case c.n > c.size:
+		return n, ErrFileSizeExceeded
+	case err == io.EOF && c.n != c.size:
+		return n, ErrChunkSizeMismatch
+	}
+	return n, err
+}
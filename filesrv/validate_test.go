@@ -0,0 +1,89 @@
+package filesrv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestValidateChunk(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		chunkNum int
+		want     error
+	}{
+		{"first chunk", SessionInProgress, 0, nil},
+		{"last chunk", SessionInProgress, 3, nil},
+		{"negative chunk", SessionInProgress, -1, ErrChunkOutOfRange},
+		{"chunk past the end", SessionInProgress, 4, ErrChunkOutOfRange},
+		{"finalizing session", SessionFinalizing, 0, ErrSessionNotInProgress},
+		{"completed session", SessionCompleted, 0, ErrSessionNotInProgress},
+		{"expired session", SessionExpired, 0, ErrSessionNotInProgress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := UploadMetadata{Status: tt.status, TotalChunks: 4}
+			if err := validateChunk(meta, tt.chunkNum); !errors.Is(err, tt.want) {
+				t.Errorf("validateChunk() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFileSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		totalChunks int
+		chunkSize   int
+		fileSize    int64
+		want        error
+	}{
+		{"exact multiple", 4, 10, 40, nil},
+		{"short last chunk", 4, 10, 31, nil},
+		{"empty file without chunks", 0, 10, 0, nil},
+		{"empty file as one chunk", 1, 10, 0, nil},
+		{"too few chunks", 3, 10, 31, ErrInvalidFileSize},
+		{"too many chunks", 5, 10, 40, ErrInvalidFileSize},
+		{"zero chunk size", 1, 0, 10, ErrInvalidFileSize},
+		{"negative chunk count", -1, 10, 0, ErrInvalidFileSize},
+		{"negative file size", 1, 10, -1, ErrInvalidFileSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFileSize(tt.totalChunks, tt.chunkSize, tt.fileSize)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("validateFileSize() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkSizeReader(t *testing.T) {
+	// Three chunks of 4 bytes declared, the last one holding 2 bytes.
+	meta := UploadMetadata{TotalChunks: 3, ChunkSize: 4, FileSize: 10}
+
+	tests := []struct {
+		name     string
+		chunkNum int
+		size     int
+		want     error
+	}{
+		{"full chunk", 0, 4, nil},
+		{"short chunk", 0, 3, ErrChunkSizeMismatch},
+		{"long chunk", 1, 5, ErrChunkSizeMismatch},
+		{"last chunk", 2, 2, nil},
+		{"short last chunk", 2, 1, ErrChunkSizeMismatch},
+		{"last chunk beyond file size", 2, 3, ErrFileSizeExceeded},
+		{"last chunk beyond chunk size", 2, 5, ErrChunkTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newChunkSizeReader(bytes.NewReader(make([]byte, tt.size)), meta, tt.chunkNum)
+			if _, err := io.ReadAll(r); !errors.Is(err, tt.want) {
+				t.Errorf("reading chunk = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,20 +1,28 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
 	"syscall"
 	"time"
 
 	"github.com/ckshitij/file-mgmt-srv/config"
 	dbmongo "github.com/ckshitij/file-mgmt-srv/db-mongo"
 	"github.com/ckshitij/file-mgmt-srv/filesrv"
+	"github.com/go-kit/kit/endpoint"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	logkit "github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -44,11 +52,6 @@ func main() {
 	}
 
 	db := client.GetDatabase("upload_service")
-	fsBucket, _ := gridfs.NewBucket(db, &options.BucketOptions{
-		Name:           &CollectionName,
-		ChunkSizeBytes: &GridFSChunkSize,
-	})
-	uploadsCollection := db.Collection(CollectionName)
 
 	var logger logkit.Logger
 	{
@@ -57,17 +60,94 @@ func main() {
 		logger = logkit.With(logger, "caller", logkit.DefaultCaller)
 	}
 
+	var signingKeys [][]byte
+	for _, secret := range cfg.Signing.Secrets {
+		signingKeys = append(signingKeys, []byte(secret))
+	}
+	signer := filesrv.NewURLSigner(signingKeys...)
+
+	multiTenant := len(cfg.Tenancy.Tenants) > 0
+
 	var svc filesrv.FileService
 	{
-		svc = filesrv.NewFileService(uploadsCollection, fsBucket)
+		if multiTenant {
+			svc = filesrv.NewTenantRouter(func(ctx context.Context, tenant string) (filesrv.FileService, error) {
+				tenantCfg, ok := cfg.Tenant(tenant)
+				if !ok {
+					return nil, filesrv.ErrUnknownTenant
+				}
+				staging := cfg.Staging
+				staging.Dir = filepath.Join(cmp.Or(staging.Dir, "./tmp_uploads"), tenant)
+				return newFileService(ctx, cfg, client.GetDatabase(tenantCfg.Database), tenantCfg, staging, signer)
+			}, slices.Sorted(maps.Keys(cfg.Tenancy.Tenants))...)
+		} else {
+			svc, err = newFileService(ctx, cfg, db, config.TenantConfig{
+				Storage: cfg.Storage,
+				Limits:  cfg.Limits,
+				Quotas:  cfg.Quotas,
+			}, cfg.Staging, signer)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		svc = filesrv.InstrumentingMiddleware(newMetrics())(svc)
 		svc = filesrv.LoggingMiddleware(logger)(svc)
 	}
 
-	endpoints := filesrv.MakeEndpoints(svc)
+	if _, err := svc.ReconcileStaging(ctx); err != nil {
+		log.Fatalf("failed to reconcile staged chunks: %v", err)
+	}
+
+	bgCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+
+	if cfg.Trash.RetentionDays > 0 {
+		retention := time.Duration(cfg.Trash.RetentionDays) * 24 * time.Hour
+		trashLogger := logkit.With(logger, "component", "trash")
+		go runPeriodically(bgCtx, cfg.Trash.PurgeInterval, func(ctx context.Context) {
+			purged, err := svc.PurgeTrash(ctx, time.Now().Add(-retention))
+			if err != nil {
+				return
+			}
+			if err := level.Info(trashLogger).Log("msg", "purged trash", "purged", purged); err != nil {
+				fmt.Println("log error:", err)
+			}
+		})
+	}
+
+	sessionLogger := logkit.With(logger, "component", "sessions")
+	go runPeriodically(bgCtx, cfg.Sessions.ReapInterval, func(ctx context.Context) {
+		expired, err := svc.ExpireSessions(ctx)
+		if err != nil {
+			return
+		}
+		if err := level.Info(sessionLogger).Log("msg", "reclaimed expired upload sessions", "expired", expired); err != nil {
+			fmt.Println("log error:", err)
+		}
+	})
+
+	go runPeriodically(bgCtx, cmp.Or(cfg.Metrics.SampleInterval, 30*time.Second), func(ctx context.Context) {
+		// The instrumenting middleware updates the session gauges.
+		_, _ = svc.GetStats(ctx)
+	})
+
+	authenticators := []filesrv.Authenticator{
+		filesrv.NewAPIKeyAuthenticator(db.Collection(cmp.Or(cfg.Auth.APIKeyCollection, "api_keys"))),
+	}
+	if cfg.Auth.JWTSecret != "" {
+		authenticators = append(authenticators, filesrv.NewJWTAuthenticator([]byte(cfg.Auth.JWTSecret)))
+	}
+
+	middlewares := []endpoint.Middleware{filesrv.Authenticate(authenticators...)}
+	if multiTenant {
+		middlewares = append(middlewares, filesrv.ResolveTenant(cfg.Tenancy.DefaultTenant))
+	}
+	endpoints := filesrv.MakeEndpoints(svc, middlewares...)
 
 	var handler http.Handler
 	{
-		handler = filesrv.MakeHTTPHandler(endpoints, logkit.With(logger, "component", "HTTP"))
+		tenants := filesrv.TenantResolver{Header: cfg.Tenancy.Header, BaseDomain: cfg.Tenancy.BaseDomain}
+		handler = filesrv.MakeHTTPHandler(endpoints, signer, tenants, newHTTPMetrics(), logkit.With(logger, "component", "HTTP"))
 	}
 
 	errs := make(chan error)
@@ -95,8 +175,174 @@ func main() {
 	if err := logger.Log("exit", <-errs); err != nil {
 		fmt.Println("error will logging server exit error")
 	}
+	stopBackground()
 	if err := client.Disconnect(ctx); err != nil {
 		fmt.Println("failed to disconnect the mongo client with error : ", err.Error())
 	}
 	close(errs)
 }
+
+// newFileService builds the FileService storing files in the GridFS bucket
+// and metadata collection of db named by tenant.Bucket, or in the storage
+// backend selected for it.
+func newFileService(ctx context.Context, cfg *config.Config, db *mongo.Database, tenant config.TenantConfig, staging config.StagingConfig, signer *filesrv.URLSigner) (filesrv.FileService, error) {
+	bucketName := cmp.Or(tenant.Bucket, CollectionName)
+	fsBucket, err := gridfs.NewBucket(db, &options.BucketOptions{
+		Name:           &bucketName,
+		ChunkSizeBytes: &GridFSChunkSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := newBlobStore(tenant.Storage, fsBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	stager, err := newChunkStager(ctx, staging, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return filesrv.NewFileService(db.Collection(bucketName), db.Collection("quotas"), blobs, stager, filesrv.Options{
+		SessionTTL:      cfg.Sessions.TTL,
+		FinalizeTimeout: cfg.Sessions.FinalizeTimeout,
+		MaxFileSize:     tenant.Limits.MaxFileSize,
+		DefaultQuota: filesrv.Quota{
+			MaxBytes: tenant.Quotas.DefaultMaxBytes,
+			MaxFiles: tenant.Quotas.DefaultMaxFiles,
+		},
+		AdminGroup:       cfg.Auth.AdminGroup,
+		Signer:           signer,
+		SignedURLBase:    cfg.Signing.BaseURL,
+		SignedURLTTL:     cfg.Signing.DefaultTTL,
+		MaxSignedURLTTL:  cfg.Signing.MaxTTL,
+		OpenUnownedFiles: cfg.Auth.OpenUnownedFiles,
+	}), nil
+}
+
+// newMetrics creates the Prometheus instruments of the FileService,
+// registered with the default registry served at /metrics.
+func newMetrics() filesrv.Metrics {
+	const namespace, subsystem = "file_mgmt", "file_service"
+	methodOutcome := []string{"method", "outcome"}
+	return filesrv.Metrics{
+		Requests: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Number of FileService calls by method and outcome.",
+		}, methodOutcome),
+		RequestLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of FileService calls in seconds by method and outcome.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, methodOutcome),
+		BytesUploaded: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "uploaded_bytes_total",
+			Help:      "Bytes of accepted chunks.",
+		}, nil),
+		BytesDownloaded: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "downloaded_bytes_total",
+			Help:      "Bytes sent from downloaded files.",
+		}, nil),
+		ActiveSessions: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "active_upload_sessions",
+			Help:      "Upload sessions in progress or finalizing.",
+		}, nil),
+		RecordedChunkBytes: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "recorded_chunk_bytes",
+			Help:      "Sum of the chunk lengths recorded in the metadata of unfinished upload sessions.",
+		}, nil),
+		FinalizeDuration: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "finalize_duration_seconds",
+			Help:      "Duration of successful finalize calls in seconds.",
+			Buckets:   stdprometheus.ExponentialBuckets(0.1, 2, 12),
+		}, nil),
+	}
+}
+
+// newHTTPMetrics creates the Prometheus instruments of the API routes,
+// registered with the default registry served at /metrics.
+func newHTTPMetrics() filesrv.HTTPMetrics {
+	const namespace, subsystem = "file_mgmt", "http"
+	routeCode := []string{"route", "code"}
+	return filesrv.HTTPMetrics{
+		Requests: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Number of HTTP requests to the API by route and status code.",
+		}, routeCode),
+		RequestLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of HTTP requests to the API in seconds by route and status code.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, routeCode),
+	}
+}
+
+// newBlobStore builds the BlobStore selected by the storage configuration.
+func newBlobStore(cfg config.StorageConfig, fsBucket *gridfs.Bucket) (filesrv.BlobStore, error) {
+	switch cfg.Backend {
+	case "", config.StorageBackendGridFS:
+		return filesrv.NewGridFSBlobStore(fsBucket), nil
+	case config.StorageBackendLocal:
+		return filesrv.NewLocalBlobStore(cfg.LocalDir)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
+
+// newChunkStager builds the ChunkStager selected by the staging configuration.
+func newChunkStager(ctx context.Context, cfg config.StagingConfig, db *mongo.Database) (filesrv.ChunkStager, error) {
+	switch cfg.Backend {
+	case "", config.StagingBackendDisk:
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "./tmp_uploads"
+		}
+		return filesrv.NewDiskChunkStager(dir), nil
+	case config.StagingBackendMongo:
+		collection := cfg.Collection
+		if collection == "" {
+			collection = "upload_chunks"
+		}
+		return filesrv.NewMongoChunkStager(ctx, db.Collection(collection))
+	default:
+		return nil, fmt.Errorf("unknown staging backend %q", cfg.Backend)
+	}
+}
+
+// runPeriodically calls task immediately and then every interval until ctx
+// is cancelled. An interval of zero or less defaults to one hour.
+func runPeriodically(ctx context.Context, interval time.Duration, task func(context.Context)) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		task(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}